@@ -0,0 +1,806 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"k3air/internal/config"
+	"k3air/internal/install"
+	"k3air/internal/state"
+)
+
+// command is a subcommand: its flags, a one-line synopsis and summary for
+// usage output and shell completion, and the function that runs it
+type command struct {
+	name    string
+	usage   string
+	summary string
+	flags   *flag.FlagSet
+	// nargs is the number of positional arguments the command takes
+	nargs int
+	// run executes the command after its flags have been parsed and returns
+	// the process exit code
+	run func(ctx context.Context, e *env) int
+}
+
+// env is the state shared by all commands, derived from the global flags
+type env struct {
+	out       io.Writer
+	logFormat string
+	loadOpts  config.LoadOptions
+	// errOut is stderr plus the log file, for logs of commands that print
+	// machine readable output on stdout
+	errOut io.Writer
+}
+
+// newCommands returns every subcommand in the order they are listed in the
+// usage output
+func newCommands() []*command {
+	cmds := []*command{
+		newApplyCommand(),
+		newAddAgentCommand(),
+		newUpgradeCommand(),
+		newResetCommand(),
+		newValidateCommand(),
+		newLogsCommand(),
+		newGetCommand(),
+		newInitCommand(),
+		newConfigCommand(),
+	}
+	cmds = append(cmds, newCompletionCommand(&cmds))
+	for _, c := range cmds {
+		c.flags.Usage = c.printUsage
+	}
+	return cmds
+}
+
+// printUsage prints the synopsis and flags of c to its flagset's output
+func (c *command) printUsage() {
+	w := c.flags.Output()
+	fmt.Fprintln(w, strings.TrimSpace("usage: k3air "+c.name+" "+c.usage))
+	c.flags.PrintDefaults()
+}
+
+// parse parses the arguments of c, printing its usage when they are invalid.
+// Errors are prefixed with the command name; flag.ErrHelp is wrapped when
+// help was requested.
+func (c *command) parse(args []string) error {
+	if err := c.flags.Parse(args); err != nil {
+		return fmt.Errorf("%s: %w", c.name, err)
+	}
+	if c.flags.NArg() != c.nargs {
+		err := fmt.Errorf("%s: unexpected arguments %q", c.name, c.flags.Args())
+		if c.flags.NArg() < c.nargs {
+			err = fmt.Errorf("%s: missing arguments", c.name)
+		}
+		fmt.Fprintln(c.flags.Output(), err)
+		c.printUsage()
+		return err
+	}
+	return nil
+}
+
+// loadState reads the cluster state kept next to the config file and warns
+// about nodes the config and the installed cluster disagree on
+func loadState(cfgPath string, cfg config.Config) (*state.State, string, error) {
+	path := state.Path(cfgPath)
+	st, err := state.Load(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if st.Empty() {
+		return st, path, nil
+	}
+	configured := make(map[string]bool)
+	for _, n := range append(append([]config.Node{}, cfg.Servers...), cfg.Agents...) {
+		configured[n.IP] = true
+		if _, ok := st.FindNode(n.IP); !ok {
+			slog.Warn("node is in the config but was never installed", "node", n.NodeName, "ip", n.IP, "state", path)
+		}
+	}
+	for _, n := range st.Nodes {
+		if !configured[n.IP] {
+			slog.Warn("installed node is missing from the config", "node", n.Name, "ip", n.IP, "state", path)
+		}
+	}
+	if st.Token != "" && cfg.Cluster.Token != "" && st.Token != cfg.Cluster.Token {
+		slog.Warn("cluster token differs from the one the cluster was installed with", "state", path)
+	}
+	return st, path, nil
+}
+
+// useStateToken falls back to the token the cluster was installed with, as
+// recorded in the state, when the config sets none
+func useStateToken(cfg *config.Config, st *state.State) error {
+	if cfg.Cluster.Token != "" {
+		return nil
+	}
+	if st.Token == "" {
+		return fmt.Errorf("cluster token unknown: set cluster.token or cluster.token-file, or install the cluster with apply first")
+	}
+	cfg.Cluster.Token = st.Token
+	return nil
+}
+
+// saveState writes st to path, logging instead of failing the command: the
+// cluster has been changed either way. Nothing is written before the first
+// node has been installed.
+func saveState(st *state.State, path string) {
+	if st.Empty() {
+		return
+	}
+	if err := st.Save(path); err != nil {
+		slog.Warn("failed to save cluster state", "path", path, "error", err)
+		return
+	}
+	slog.Debug("cluster state saved", "path", path)
+}
+
+// fileList is a flag that may be repeated, collecting every value
+type fileList []string
+
+func (l *fileList) String() string { return strings.Join(*l, ",") }
+
+func (l *fileList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// configFlags select the config files a command loads
+type configFlags struct {
+	files fileList
+	dir   *string
+}
+
+func addConfigFlags(fs *flag.FlagSet) *configFlags {
+	f := &configFlags{}
+	fs.Var(&f.files, "f", "`path` to config.yaml, repeat to merge several files (default init.yaml)")
+	f.dir = fs.String("config-dir", "", "merge every *.yaml and *.yml file in this directory in name order, before the -f files")
+	return f
+}
+
+// paths returns the config files in the order they are merged
+func (f *configFlags) paths() ([]string, error) {
+	var paths []string
+	if *f.dir != "" {
+		files, err := config.DirFiles(*f.dir)
+		if err != nil {
+			return nil, err
+		}
+		paths = files
+	}
+	paths = append(paths, f.files...)
+	if len(paths) == 0 {
+		paths = []string{"init.yaml"}
+	}
+	return paths, nil
+}
+
+// load merges and loads the config files. It also returns the first file,
+// next to which the token and the cluster state are kept.
+func (f *configFlags) load(opts config.LoadOptions) (config.Config, string, error) {
+	paths, err := f.paths()
+	if err != nil {
+		return config.Config{}, "", err
+	}
+	if len(paths) > 1 {
+		slog.Debug("merging config files", "files", paths)
+	}
+	cfg, err := config.LoadFiles(paths, opts)
+	return cfg, paths[0], err
+}
+
+// installFlags are the flags shared by the commands that install k3s
+type installFlags struct {
+	config               *configFlags
+	verbose              *bool
+	cmdRetries           *int
+	cmdRetryBackoff      *time.Duration
+	connectRetries       *int
+	downloadTimeout      *time.Duration
+	downloadStallTimeout *time.Duration
+	compressUploads      *bool
+	strictOS             *bool
+	maxClockSkew         *time.Duration
+	fixTime              *bool
+	trace                *bool
+	serviceTimeout       *time.Duration
+}
+
+func addInstallFlags(fs *flag.FlagSet) *installFlags {
+	return &installFlags{
+		config:               addConfigFlags(fs),
+		verbose:              fs.Bool("verbose", false, "enable verbose logging"),
+		cmdRetries:           fs.Int("cmd-retries", install.DefaultCmdRetries, "attempts for remote commands that may fail transiently"),
+		cmdRetryBackoff:      fs.Duration("cmd-retry-backoff", install.DefaultCmdRetryBackoff, "initial delay between command retries, doubled on every attempt"),
+		connectRetries:       fs.Int("connect-retries", 1, "SSH connection attempts for nodes that refuse or time out, e.g. while booting"),
+		downloadTimeout:      fs.Duration("download-timeout", 0, "abort an asset download after this long (0 means no limit)"),
+		downloadStallTimeout: fs.Duration("download-stall-timeout", install.DefaultDownloadStallTimeout, "abort an asset download when no data arrives for this long"),
+		compressUploads:      fs.Bool("compress-uploads", false, "gzip the k3s binary for the upload and decompress it on the nodes, for slow links"),
+		strictOS:             fs.Bool("strict-os", false, "abort on nodes with an unsupported kernel or cgroup setup instead of warning"),
+		maxClockSkew:         fs.Duration("max-clock-skew", install.DefaultMaxClockSkew, "clock difference between nodes and this machine that preflight tolerates"),
+		fixTime:              fs.Bool("fix-time", false, "enable NTP (timedatectl, chronyc) on nodes whose clock is skewed"),
+		trace:                fs.Bool("trace", false, "log every remote command with its full output, secrets masked (implies --verbose)"),
+		serviceTimeout:       fs.Duration("service-timeout", install.DefaultServiceTimeout, "abort a systemctl enable or restart of k3s that hangs for this long"),
+	}
+}
+
+// debug reports whether debug logging was requested by --verbose or --trace
+func (f *installFlags) debug() bool {
+	return *f.verbose || *f.trace
+}
+
+// options returns the installer options set by the flags
+func (f *installFlags) options(out io.Writer) install.Options {
+	return install.Options{
+		Verbose:              f.debug(),
+		CmdRetries:           *f.cmdRetries,
+		CmdRetryBackoff:      *f.cmdRetryBackoff,
+		ConnectRetries:       *f.connectRetries,
+		DownloadTimeout:      *f.downloadTimeout,
+		DownloadStallTimeout: *f.downloadStallTimeout,
+		Output:               out,
+		CompressUploads:      *f.compressUploads,
+		StrictOS:             *f.strictOS,
+		MaxClockSkew:         *f.maxClockSkew,
+		FixTime:              *f.fixTime,
+		Trace:                *f.trace,
+		ServiceTimeout:       *f.serviceTimeout,
+	}
+}
+
+// newInstaller creates an installer for cfg and returns it with a function
+// that removes its temporary files
+func newInstaller(cfg config.Config, opts install.Options) (*install.Installer, func(), error) {
+	inst, err := install.NewInstaller(cfg, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		if err := inst.Cleanup(); err != nil {
+			slog.Warn("cleanup failed", "error", err)
+		}
+	}
+	return inst, cleanup, nil
+}
+
+// writeJSON writes v to w as indented JSON
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func newApplyCommand() *command {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	flags := addInstallFlags(fs)
+	skipPreflight := fs.Bool("skip-preflight", false, "warn about failed preflight checks instead of aborting (unreachable nodes still abort)")
+	var only, skip nodeList
+	fs.Var(&only, "only", "comma separated node names or IPs to install, leaving the other nodes untouched")
+	fs.Var(&skip, "skip", "comma separated node names or IPs to leave untouched")
+	force := fs.Bool("force", false, "reinstall servers that already run k3s instead of only updating their service config")
+	keepGoing := fs.Bool("keep-going", false, "record a failed node and continue with the others, reporting every failure at the end (the primary server still aborts)")
+	resume := fs.Bool("resume", false, "skip the nodes a previous apply installed and that still run k3s, continuing from the first incomplete node; k3s files left on the remaining nodes pass preflight and are installed again")
+	kubeconfigPath := fs.String("kubeconfig-path", "", "local path to write the kubeconfig to (overrides cluster.kubeconfig-path)")
+	kubeconfigContext := fs.String("kubeconfig-context", "", "name for the kubeconfig cluster/user/context (overrides cluster.kubeconfig-context)")
+	kubeconfigServer := fs.String("kubeconfig-server", "", "host or host:port, e.g. a load balancer's DNS name, for the kubeconfig server URL (overrides cluster.kubeconfig-server)")
+	fs.StringVar(kubeconfigServer, "kubeconfig-server-override", "", "same as --kubeconfig-server")
+	kubeconfigFrom := fs.String("kubeconfig-from", "", "node name or IP of the server to download the kubeconfig from, and point it at, instead of the primary")
+	noDownloadKubeconfig := fs.Bool("no-download-kubeconfig", false, "leave the kubeconfig on the servers instead of downloading it, e.g. in pipelines; fetch it later with get kubeconfig")
+	output := fs.String("output", "text", "result format: text, or json for a machine readable result on stdout (logs go to stderr)")
+	primaryReadyTimeout := fs.Duration("primary-ready-timeout", install.DefaultPrimaryReadyTimeout, "abort when the primary server's API server is not ready this long after its install")
+
+	return &command{
+		name:    "apply",
+		usage:   "-f <config path>",
+		summary: "Deploy a k3s cluster",
+		flags:   fs,
+		run: func(ctx context.Context, e *env) int {
+			if *output != "text" && *output != "json" {
+				fmt.Fprintf(e.out, "invalid --output %q: must be text or json\n", *output)
+				return 2
+			}
+			// In JSON mode stdout carries nothing but the result
+			jsonOutput := *output == "json"
+			logOut := e.out
+			if jsonOutput {
+				logOut = e.errOut
+			}
+			setupLogger(logOut, flags.debug(), e.logFormat)
+			result := &install.ApplyResult{Nodes: []install.NodeResult{}}
+			// fail reports an error that stops apply before any node is touched
+			fail := func(err error) int {
+				fmt.Fprintln(logOut, err)
+				if jsonOutput {
+					result.Error = err.Error()
+					writeJSON(e.out, result)
+				}
+				return 1
+			}
+
+			loadOpts := e.loadOpts
+			loadOpts.GenerateToken = true
+			cfg, cfgPath, err := flags.config.load(loadOpts)
+			if err != nil {
+				return fail(fmt.Errorf("failed to load config: %w", err))
+			}
+			if err := cfg.PreflightFiles(); err != nil {
+				return fail(err)
+			}
+			if *kubeconfigPath != "" {
+				cfg.Cluster.KubeconfigPath = *kubeconfigPath
+			}
+			if *kubeconfigContext != "" {
+				cfg.Cluster.KubeconfigContext = *kubeconfigContext
+			}
+			if *kubeconfigServer != "" {
+				cfg.Cluster.KubeconfigServer = *kubeconfigServer
+				if err := cfg.ValidateKubeconfigServer(); err != nil {
+					return fail(err)
+				}
+			}
+			if *kubeconfigFrom != "" {
+				if err := cfg.ValidateKubeconfigFrom(*kubeconfigFrom); err != nil {
+					return fail(fmt.Errorf("invalid --kubeconfig-from: %w", err))
+				}
+			}
+			slog.Info("cluster config", "pod cidr", cfg.Cluster.ClusterCidr, "service cidr", cfg.Cluster.ServiceCidr)
+			st, statePath, err := loadState(cfgPath, cfg)
+			if err != nil {
+				return fail(err)
+			}
+			opts := flags.options(e.out)
+			opts.SkipPreflight = *skipPreflight
+			opts.State = st
+			opts.Force = *force
+			opts.Only = only
+			opts.Skip = skip
+			opts.Result = result
+			opts.PrimaryReadyTimeout = *primaryReadyTimeout
+			opts.KubeconfigFrom = *kubeconfigFrom
+			opts.Resume = *resume
+			opts.KeepGoing = *keepGoing
+			opts.NoDownloadKubeconfig = *noDownloadKubeconfig
+			if jsonOutput {
+				opts.Output = io.Discard
+				opts.NoProgress = true
+			}
+			inst, cleanup, err := newInstaller(cfg, opts)
+			if err != nil {
+				return fail(fmt.Errorf("failed to create installer: %w", err))
+			}
+			defer cleanup()
+			defer saveState(st, statePath)
+			err = inst.Apply(ctx)
+			if jsonOutput {
+				if err := writeJSON(e.out, result); err != nil {
+					slog.Error("failed to write result", "error", err)
+					return 1
+				}
+			}
+			if err != nil {
+				slog.Error("apply failed", "error", err)
+				return 1
+			}
+			if !jsonOutput {
+				fmt.Fprintln(e.out, "apply completed")
+			}
+			return 0
+		},
+	}
+}
+
+func newAddAgentCommand() *command {
+	fs := flag.NewFlagSet("add-agent", flag.ContinueOnError)
+	flags := addInstallFlags(fs)
+	skipPreflight := fs.Bool("skip-preflight", false, "warn about failed preflight checks instead of aborting (unreachable nodes still abort)")
+	nodesFile := fs.String("nodes-file", "", "path to a yaml file with an agents list to join")
+	var specs nodeSpecs
+	fs.Var(&specs, "node", "agent to join as name=...,ip=...,user=...,password=...,key_path=... (repeatable)")
+
+	return &command{
+		name:    "add-agent",
+		usage:   "-f <config path> --node name=...,ip=...",
+		summary: "Join new agents to an existing cluster",
+		flags:   fs,
+		run: func(ctx context.Context, e *env) int {
+			setupLogger(e.out, flags.debug(), e.logFormat)
+
+			cfg, cfgPath, err := flags.config.load(e.loadOpts)
+			if err != nil {
+				fmt.Fprintln(e.out, "failed to load config:", err)
+				return 1
+			}
+			if err := cfg.PreflightFiles(); err != nil {
+				fmt.Fprintln(e.out, err)
+				return 1
+			}
+			nodes := []config.Node(specs)
+			if *nodesFile != "" {
+				fileNodes, err := config.LoadNodes(*nodesFile)
+				if err != nil {
+					fmt.Fprintln(e.out, "failed to load nodes file:", err)
+					return 1
+				}
+				nodes = append(nodes, fileNodes...)
+			}
+			if err := cfg.ValidateNewAgents(nodes); err != nil {
+				fmt.Fprintln(e.out, "invalid agents:", err)
+				return 1
+			}
+			st, statePath, err := loadState(cfgPath, cfg)
+			if err != nil {
+				fmt.Fprintln(e.out, err)
+				return 1
+			}
+			if err := useStateToken(&cfg, st); err != nil {
+				fmt.Fprintln(e.out, err)
+				return 1
+			}
+			opts := flags.options(e.out)
+			opts.SkipPreflight = *skipPreflight
+			opts.State = st
+			inst, cleanup, err := newInstaller(cfg, opts)
+			if err != nil {
+				slog.Error("failed to create installer", "error", err)
+				return 1
+			}
+			defer cleanup()
+			defer saveState(st, statePath)
+			if err := inst.AddAgents(ctx, nodes); err != nil {
+				slog.Error("add-agent failed", "error", err)
+				return 1
+			}
+			fmt.Fprintln(e.out, "add-agent completed")
+			return 0
+		},
+	}
+}
+
+func newUpgradeCommand() *command {
+	fs := flag.NewFlagSet("upgrade", flag.ContinueOnError)
+	flags := addInstallFlags(fs)
+	binary := fs.String("k3s-binary", "", "URL or path of the new k3s binary (required)")
+	binarySig := fs.String("k3s-binary-sig", "", "URL or path of the signature of the new k3s binary (required when assets.signing-key is set)")
+	concurrency := fs.Int("concurrency", 1, "number of agents upgraded at the same time (servers are always upgraded one by one)")
+
+	return &command{
+		name:    "upgrade",
+		usage:   "-f <config path> --k3s-binary <url|path> [--k3s-binary-sig <url|path>]",
+		summary: "Roll a new k3s version out node by node",
+		flags:   fs,
+		run: func(ctx context.Context, e *env) int {
+			setupLogger(e.out, flags.debug(), e.logFormat)
+
+			if *binary == "" {
+				fmt.Fprintln(e.out, "--k3s-binary is required")
+				return 1
+			}
+			cfg, cfgPath, err := flags.config.load(e.loadOpts)
+			if err != nil {
+				fmt.Fprintln(e.out, "failed to load config:", err)
+				return 1
+			}
+			st, statePath, err := loadState(cfgPath, cfg)
+			if err != nil {
+				fmt.Fprintln(e.out, err)
+				return 1
+			}
+			opts := flags.options(e.out)
+			opts.State = st
+			inst, cleanup, err := newInstaller(cfg, opts)
+			if err != nil {
+				slog.Error("failed to create installer", "error", err)
+				return 1
+			}
+			defer cleanup()
+			defer saveState(st, statePath)
+			if err := inst.Upgrade(ctx, *binary, *binarySig, *concurrency); err != nil {
+				slog.Error("upgrade failed", "error", err)
+				return 1
+			}
+			fmt.Fprintln(e.out, "upgrade completed")
+			return 0
+		},
+	}
+}
+
+func newResetCommand() *command {
+	fs := flag.NewFlagSet("reset", flag.ContinueOnError)
+	flags := addInstallFlags(fs)
+	force := fs.Bool("force", false, "allow resetting the primary server, which recreates the cluster")
+
+	return &command{
+		name:    "reset",
+		usage:   "-f <config path> [--force] <node>",
+		summary: "Reinstall one broken node in place",
+		flags:   fs,
+		nargs:   1,
+		run: func(ctx context.Context, e *env) int {
+			setupLogger(e.out, flags.debug(), e.logFormat)
+
+			cfg, cfgPath, err := flags.config.load(e.loadOpts)
+			if err != nil {
+				fmt.Fprintln(e.out, "failed to load config:", err)
+				return 1
+			}
+			if err := cfg.PreflightFiles(); err != nil {
+				fmt.Fprintln(e.out, err)
+				return 1
+			}
+			st, statePath, err := loadState(cfgPath, cfg)
+			if err != nil {
+				fmt.Fprintln(e.out, err)
+				return 1
+			}
+			if err := useStateToken(&cfg, st); err != nil {
+				fmt.Fprintln(e.out, err)
+				return 1
+			}
+			opts := flags.options(e.out)
+			opts.State = st
+			inst, cleanup, err := newInstaller(cfg, opts)
+			if err != nil {
+				slog.Error("failed to create installer", "error", err)
+				return 1
+			}
+			defer cleanup()
+			defer saveState(st, statePath)
+			if err := inst.Reset(ctx, fs.Arg(0), *force); err != nil {
+				slog.Error("reset failed", "error", err)
+				return 1
+			}
+			fmt.Fprintln(e.out, "reset completed")
+			return 0
+		},
+	}
+}
+
+func newValidateCommand() *command {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	cfgFlags := addConfigFlags(fs)
+	verbose := fs.Bool("verbose", false, "enable verbose logging")
+	connectRetries := fs.Int("connect-retries", 1, "SSH connection attempts for nodes that refuse or time out, e.g. while booting")
+
+	return &command{
+		name:    "validate",
+		usage:   "-f <config path>",
+		summary: "Check the config, SSH access and assets without changing anything",
+		flags:   fs,
+		run: func(ctx context.Context, e *env) int {
+			setupLogger(e.out, *verbose, e.logFormat)
+
+			cfg, _, err := cfgFlags.load(e.loadOpts)
+			if err != nil {
+				fmt.Fprintln(e.out, "failed to load config:", err)
+				return 1
+			}
+			inst, cleanup, err := newInstaller(cfg, install.Options{Verbose: *verbose, ConnectRetries: *connectRetries, Output: e.out})
+			if err != nil {
+				slog.Error("failed to create installer", "error", err)
+				return 1
+			}
+			defer cleanup()
+			if err := inst.Validate(ctx); err != nil {
+				fmt.Fprintln(e.out, "validate failed:", err)
+				return 1
+			}
+			fmt.Fprintln(e.out, "validate completed: all checks passed")
+			return 0
+		},
+	}
+}
+
+func newLogsCommand() *command {
+	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+	cfgFlags := addConfigFlags(fs)
+	verbose := fs.Bool("verbose", false, "enable verbose logging")
+	connectRetries := fs.Int("connect-retries", 1, "SSH connection attempts for nodes that refuse or time out, e.g. while booting")
+	var lines int
+	fs.IntVar(&lines, "n", install.DefaultLogLines, "number of most recent lines to print, 0 for all")
+	fs.IntVar(&lines, "lines", install.DefaultLogLines, "same as -n")
+	since := fs.String("since", "", `only print lines since this time, e.g. "1h ago" or "2024-01-02 15:04"`)
+	follow := fs.Bool("follow", false, "keep printing new lines until Ctrl-C")
+
+	return &command{
+		name:    "logs",
+		usage:   "-f <config path> [-n <lines>] [--since <time>] [--follow] <node>",
+		summary: "Print the k3s journal of a node",
+		flags:   fs,
+		nargs:   1,
+		run: func(ctx context.Context, e *env) int {
+			// The journal goes to stdout so it can be piped, logs to stderr
+			setupLogger(e.errOut, *verbose, e.logFormat)
+
+			cfg, _, err := cfgFlags.load(e.loadOpts)
+			if err != nil {
+				fmt.Fprintln(e.errOut, "failed to load config:", err)
+				return 1
+			}
+			inst, cleanup, err := newInstaller(cfg, install.Options{Verbose: *verbose, ConnectRetries: *connectRetries, Output: e.errOut})
+			if err != nil {
+				slog.Error("failed to create installer", "error", err)
+				return 1
+			}
+			defer cleanup()
+			opts := install.LogOptions{Lines: lines, Since: *since, Follow: *follow}
+			if err := inst.Logs(ctx, fs.Arg(0), opts, os.Stdout, e.errOut); err != nil {
+				fmt.Fprintln(e.errOut, err)
+				return 1
+			}
+			return 0
+		},
+	}
+}
+
+func newGetCommand() *command {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	cfgFlags := addConfigFlags(fs)
+	verbose := fs.Bool("verbose", false, "enable verbose logging")
+	connectRetries := fs.Int("connect-retries", 1, "SSH connection attempts for nodes that refuse or time out, e.g. while booting")
+	kubeconfigPath := fs.String("kubeconfig-path", "", "local path to write the kubeconfig to (overrides cluster.kubeconfig-path)")
+	kubeconfigContext := fs.String("kubeconfig-context", "", "name for the kubeconfig cluster/user/context (overrides cluster.kubeconfig-context)")
+	kubeconfigServer := fs.String("kubeconfig-server", "", "host or host:port, e.g. a load balancer's DNS name, for the kubeconfig server URL (overrides cluster.kubeconfig-server)")
+	kubeconfigFrom := fs.String("kubeconfig-from", "", "node name or IP of the server to download the kubeconfig from, and point it at, instead of the primary")
+	merge := fs.Bool("merge", false, "merge the kubeconfig into ~/.kube/config instead of writing the kubeconfig path, replacing entries of the same name")
+
+	return &command{
+		name:    "get",
+		usage:   "-f <config path> [--merge] kubeconfig",
+		summary: "Download the kubeconfig of an installed cluster again",
+		flags:   fs,
+		nargs:   1,
+		run: func(ctx context.Context, e *env) int {
+			if fs.Arg(0) != "kubeconfig" {
+				fmt.Fprintf(e.out, "get: unknown resource %q: must be kubeconfig\n", fs.Arg(0))
+				return 2
+			}
+			setupLogger(e.out, *verbose, e.logFormat)
+
+			cfg, _, err := cfgFlags.load(e.loadOpts)
+			if err != nil {
+				fmt.Fprintln(e.out, "failed to load config:", err)
+				return 1
+			}
+			if *kubeconfigPath != "" {
+				cfg.Cluster.KubeconfigPath = *kubeconfigPath
+			}
+			if *kubeconfigContext != "" {
+				cfg.Cluster.KubeconfigContext = *kubeconfigContext
+			}
+			if *kubeconfigServer != "" {
+				cfg.Cluster.KubeconfigServer = *kubeconfigServer
+				if err := cfg.ValidateKubeconfigServer(); err != nil {
+					fmt.Fprintln(e.out, err)
+					return 1
+				}
+			}
+			if *kubeconfigFrom != "" {
+				if err := cfg.ValidateKubeconfigFrom(*kubeconfigFrom); err != nil {
+					fmt.Fprintln(e.out, "invalid --kubeconfig-from:", err)
+					return 1
+				}
+			}
+			opts := install.Options{Verbose: *verbose, ConnectRetries: *connectRetries, Output: e.out, KubeconfigFrom: *kubeconfigFrom}
+			inst, cleanup, err := newInstaller(cfg, opts)
+			if err != nil {
+				slog.Error("failed to create installer", "error", err)
+				return 1
+			}
+			defer cleanup()
+			if err := inst.GetKubeconfig(ctx, *merge); err != nil {
+				slog.Error("get kubeconfig failed", "error", err)
+				return 1
+			}
+			return 0
+		},
+	}
+}
+
+func newConfigCommand() *command {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+
+	return &command{
+		name:    "config",
+		usage:   "schema",
+		summary: "Print the JSON Schema of the config file, for editor completion and checks",
+		flags:   fs,
+		nargs:   1,
+		run: func(ctx context.Context, e *env) int {
+			if fs.Arg(0) != "schema" {
+				fmt.Fprintf(e.out, "config: unknown subcommand %q: must be schema\n", fs.Arg(0))
+				return 2
+			}
+			// The schema goes to stdout only, so it can be redirected to a file
+			b, err := json.MarshalIndent(config.Schema(), "", "  ")
+			if err != nil {
+				fmt.Fprintln(e.out, "failed to generate the schema:", err)
+				return 1
+			}
+			fmt.Println(string(b))
+			return 0
+		},
+	}
+}
+
+func newInitCommand() *command {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	output := fs.String("output", "init.yaml", "path to write the config to")
+	force := fs.Bool("force", false, "overwrite an existing config")
+	var servers, agents nodeList
+	fs.Var(&servers, "servers", "comma separated server IPs, the first one becomes the primary")
+	fs.Var(&agents, "agents", "comma separated agent IPs")
+	token := fs.String("token", "", "cluster token to write into the config")
+	k3sVersion := fs.String("k3s-version", "", "k3s release to install, such as v1.31.4+k3s1")
+
+	return &command{
+		name:    "init",
+		usage:   "[--output <path>] [--servers <ips>] [--agents <ips>]",
+		summary: "Create a default config.yaml",
+		flags:   fs,
+		run: func(ctx context.Context, e *env) int {
+			for _, ip := range append(append([]string{}, servers...), agents...) {
+				if net.ParseIP(ip) == nil {
+					fmt.Fprintf(e.out, "invalid node ip %q\n", ip)
+					return 2
+				}
+			}
+			out := *output
+			if _, err := os.Stat(out); err == nil && !*force {
+				fmt.Fprintf(e.out, "%s already exists, use --force to overwrite it\n", out)
+				return 1
+			}
+			content, err := config.GetTemplate(config.TemplateVars{
+				Servers:    servers,
+				Agents:     agents,
+				Token:      *token,
+				K3sVersion: *k3sVersion,
+			})
+			if err != nil {
+				fmt.Fprintln(e.out, "failed to render template:", err)
+				return 1
+			}
+			// The rendered config must load, so flag values cannot produce a broken file
+			var cfg config.Config
+			if err := yaml.Unmarshal(content, &cfg); err != nil {
+				fmt.Fprintln(e.out, "invalid config generated from the flags:", err)
+				return 2
+			}
+			if err := os.WriteFile(out, content, 0644); err != nil {
+				fmt.Fprintf(e.out, "failed to write %s: %v\n", out, err)
+				return 1
+			}
+			fmt.Fprintf(e.out, "created %s ✅，please edit it and run k3air apply -f %s\n", out, out)
+			return 0
+		},
+	}
+}
+
+// newCompletionCommand returns the completion command. cmds points at the
+// registry it is part of, which is only complete once it has been built.
+func newCompletionCommand(cmds *[]*command) *command {
+	fs := flag.NewFlagSet("completion", flag.ContinueOnError)
+	return &command{
+		name:    "completion",
+		usage:   "bash|zsh|fish",
+		summary: "Print a shell completion script",
+		flags:   fs,
+		nargs:   1,
+		run: func(ctx context.Context, e *env) int {
+			if err := writeCompletion(os.Stdout, fs.Arg(0), flag.CommandLine, *cmds); err != nil {
+				fmt.Println(err)
+				return 1
+			}
+			return 0
+		},
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"strings"
+	"testing"
+)
+
+// findCommand returns the subcommand called name, with its usage output
+// discarded
+func findCommand(t *testing.T, name string) *command {
+	t.Helper()
+	for _, c := range newCommands() {
+		if c.name == name {
+			c.flags.SetOutput(io.Discard)
+			return c
+		}
+	}
+	t.Fatalf("no %s command", name)
+	return nil
+}
+
+func TestCommandParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"apply", []string{"-badflag"}, "apply: flag provided but not defined: -badflag"},
+		{"init", []string{"extra-arg"}, `init: unexpected arguments ["extra-arg"]`},
+		{"reset", nil, "reset: missing arguments"},
+	}
+	for _, tt := range tests {
+		err := findCommand(t, tt.name).parse(tt.args)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%s %s: got error %v, want %q", tt.name, strings.Join(tt.args, " "), err, tt.want)
+		}
+	}
+}
+
+func TestCommandParseHelp(t *testing.T) {
+	err := findCommand(t, "apply").parse([]string{"-h"})
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("got error %v, want flag.ErrHelp", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// fileFlags are the flags whose value is a local file path
+var fileFlags = map[string]bool{
+	"f":              true,
+	"nodes-file":     true,
+	"log-file":       true,
+	"k3s-binary":     true,
+	"k3s-binary-sig": true,
+	"config-dir":     true,
+	"assets-dir":     true,
+}
+
+// flagNames returns the flags of fs as typed on the command line: -x for
+// single letter flags, --name otherwise
+func flagNames(fs *flag.FlagSet) []string {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		names = append(names, dashed(f.Name))
+	})
+	return names
+}
+
+func dashed(name string) string {
+	if len(name) == 1 {
+		return "-" + name
+	}
+	return "--" + name
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// writeCompletion writes the completion script for shell to w
+func writeCompletion(w io.Writer, shell string, global *flag.FlagSet, cmds []*command) error {
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, global, cmds)
+	case "zsh":
+		// zsh runs the bash completion through its compatibility layer
+		fmt.Fprintln(w, "#compdef k3air")
+		fmt.Fprintln(w, "autoload -U +X bashcompinit && bashcompinit")
+		writeBashCompletion(w, global, cmds)
+	case "fish":
+		writeFishCompletion(w, global, cmds)
+	default:
+		return fmt.Errorf("unsupported shell %q: must be bash, zsh or fish", shell)
+	}
+	return nil
+}
+
+func writeBashCompletion(w io.Writer, global *flag.FlagSet, cmds []*command) {
+	var names, files []string
+	for _, c := range cmds {
+		names = append(names, c.name)
+	}
+	for name := range fileFlags {
+		files = append(files, dashed(name))
+	}
+
+	fmt.Fprintln(w, "# bash completion for k3air")
+	fmt.Fprintln(w, "_k3air() {")
+	fmt.Fprintln(w, `    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" cmd="" w`)
+	fmt.Fprintf(w, "    case \"$prev\" in\n        %s) COMPREPLY=($(compgen -f -- \"$cur\")); return;;\n    esac\n", strings.Join(files, "|"))
+	fmt.Fprintln(w, `    for w in "${COMP_WORDS[@]:1:COMP_CWORD-1}"; do`)
+	fmt.Fprintf(w, "        case \"$w\" in %s) cmd=\"$w\"; break;; esac\n", strings.Join(names, "|"))
+	fmt.Fprintln(w, "    done")
+	fmt.Fprintln(w, `    case "$cmd" in`)
+	for _, c := range cmds {
+		words := flagNames(c.flags)
+		if c.name == "completion" {
+			words = append(words, "bash", "zsh", "fish")
+		}
+		if c.name == "get" {
+			words = append(words, "kubeconfig")
+		}
+		if c.name == "config" {
+			words = append(words, "schema")
+		}
+		fmt.Fprintf(w, "        %s) COMPREPLY=($(compgen -W %q -- \"$cur\"));;\n", c.name, strings.Join(words, " "))
+	}
+	top := append(names, flagNames(global)...)
+	fmt.Fprintf(w, "        *) COMPREPLY=($(compgen -W %q -- \"$cur\"));;\n", strings.Join(top, " "))
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -F _k3air k3air")
+}
+
+func writeFishCompletion(w io.Writer, global *flag.FlagSet, cmds []*command) {
+	fmt.Fprintln(w, "# fish completion for k3air")
+	fmt.Fprintln(w, "complete -c k3air -f")
+	fishFlags(w, "__fish_use_subcommand", global)
+	for _, c := range cmds {
+		fmt.Fprintf(w, "complete -c k3air -n __fish_use_subcommand -a %s -d %s\n", c.name, fishQuote(c.summary))
+		cond := "'__fish_seen_subcommand_from " + c.name + "'"
+		fishFlags(w, cond, c.flags)
+		if c.name == "completion" {
+			fmt.Fprintf(w, "complete -c k3air -n %s -a 'bash zsh fish'\n", cond)
+		}
+		if c.name == "get" {
+			fmt.Fprintf(w, "complete -c k3air -n %s -a kubeconfig\n", cond)
+		}
+		if c.name == "config" {
+			fmt.Fprintf(w, "complete -c k3air -n %s -a schema\n", cond)
+		}
+	}
+}
+
+func fishFlags(w io.Writer, cond string, fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		opt := "-l " + f.Name
+		if len(f.Name) == 1 {
+			opt = "-s " + f.Name
+		}
+		switch {
+		case fileFlags[f.Name]:
+			opt += " -r -F"
+		case !isBoolFlag(f):
+			opt += " -r"
+		}
+		fmt.Fprintf(w, "complete -c k3air -n %s %s -d %s\n", cond, opt, fishQuote(f.Usage))
+	})
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), "'", `\'`) + "'"
+}
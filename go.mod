@@ -3,19 +3,19 @@ module k3air
 go 1.22
 
 require (
+	github.com/fatih/color v1.18.0
 	github.com/pkg/sftp v1.13.6
 	github.com/schollz/progressbar/v3 v3.18.0
 	golang.org/x/crypto v0.23.0
+	golang.org/x/term v0.28.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/fatih/color v1.18.0 // indirect
 	github.com/kr/fs v0.1.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
 	golang.org/x/sys v0.29.0 // indirect
-	golang.org/x/term v0.28.0 // indirect
 )
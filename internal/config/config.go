@@ -1,38 +1,251 @@
 package config
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
+	"k3air/internal/sshclient"
 )
 
 type AssetSource struct {
-	K3sBinary       string `yaml:"k3s-binary"`
+	K3sBinary        string `yaml:"k3s-binary"`
 	K3sAirgapTarball string `yaml:"k3s-airgap-tarball"`
+	// K3sBinaryURLs and K3sAirgapTarballURLs are mirrors tried in order when
+	// the primary source of the asset cannot be resolved
+	K3sBinaryURLs        []string `yaml:"k3s-binary-urls"`
+	K3sAirgapTarballURLs []string `yaml:"k3s-airgap-tarball-urls"`
+	// Proxy is used for downloading URL assets, overriding HTTP(S)_PROXY
+	Proxy string `yaml:"proxy"`
+	// K3sBinarySig is the URL or path of a detached signature of the k3s
+	// binary, verified with SigningKey before the binary is uploaded
+	K3sBinarySig string `yaml:"k3s-binary-sig"`
+	// SigningKey is a local public key: an armored OpenPGP key, or a PEM
+	// ECDSA, Ed25519 or RSA key such as a cosign.pub
+	SigningKey string `yaml:"signing-key"`
+	// CAFile is a local PEM file of root CAs trusted for HTTPS downloads,
+	// added to the system roots, for mirrors with a private CA
+	CAFile string `yaml:"ca-file"`
+	// InsecureSkipTLSVerify downloads over HTTPS without verifying the
+	// server certificate
+	InsecureSkipTLSVerify bool `yaml:"insecure-skip-tls-verify"`
 }
 
 type Cluster struct {
-	FlannelBackend   string   `yaml:"flannel-backend"`
-	ClusterCidr      string   `yaml:"cluster-cidr"`
-	ServiceCidr      string   `yaml:"service-cidr"`
-	Token            string   `yaml:"token"`
+	FlannelBackend string `yaml:"flannel-backend"`
+	ClusterCidr    string `yaml:"cluster-cidr"`
+	ServiceCidr    string `yaml:"service-cidr"`
+	// ClusterDNS is the cluster DNS service IP, which must lie in ServiceCidr.
+	// k3s defaults to the .10 address of the service CIDR.
+	ClusterDNS string `yaml:"cluster-dns"`
+	// ClusterDomain is the cluster DNS domain, cluster.local by default
+	ClusterDomain string `yaml:"cluster-domain"`
+	Token         string `yaml:"token"`
+	// TokenFile is read into Token, keeping the secret out of the config
+	TokenFile        string   `yaml:"token-file"`
 	TLSSAN           []string `yaml:"tls-san"`
 	Disable          []string `yaml:"disable"`
 	DataDir          string   `yaml:"data-dir"`
 	EmbeddedRegistry bool     `yaml:"embedded-registry"`
 	Registries       string   `yaml:"registries"`
+	// RegistryMirrors is rendered into registries.yaml, as a structured
+	// alternative to Registries
+	RegistryMirrors map[string]RegistryMirror `yaml:"registry-mirrors"`
+	// KubeconfigPath is the local file the cluster kubeconfig is written to
+	KubeconfigPath string `yaml:"kubeconfig-path"`
+	// KubeconfigContext renames the cluster, user and context entries of the
+	// downloaded kubeconfig (k3s names them all "default")
+	KubeconfigContext string `yaml:"kubeconfig-context"`
+	// DatastoreEndpoint points all servers at an external datastore
+	// (MySQL, PostgreSQL or etcd) instead of embedded etcd
+	DatastoreEndpoint string `yaml:"datastore-endpoint"`
+	// DatastoreCAFile, DatastoreCertFile and DatastoreKeyFile are local TLS
+	// files uploaded to every server to authenticate to the datastore
+	DatastoreCAFile   string `yaml:"datastore-cafile"`
+	DatastoreCertFile string `yaml:"datastore-certfile"`
+	DatastoreKeyFile  string `yaml:"datastore-keyfile"`
+	// DisableSwap turns swap off on every node and removes it from /etc/fstab
+	DisableSwap bool `yaml:"disable-swap"`
+	// ConfigureFirewall opens the ports k3s needs in firewalld or ufw
+	ConfigureFirewall bool `yaml:"configure-firewall"`
+	// ExtraServerArgs are appended verbatim to every server's k3s command line
+	ExtraServerArgs []string `yaml:"extra-server-args"`
+	// ExtraAgentArgs are appended verbatim to every agent's k3s command line
+	ExtraAgentArgs []string `yaml:"extra-agent-args"`
+	// SSHKeepaliveInterval is how often, in seconds, SSH connections send a
+	// keepalive; 30 by default, negative disables keepalives
+	SSHKeepaliveInterval int `yaml:"ssh-keepalive-interval"`
+	// SSHConfig is an OpenSSH client config file consulted for every node.
+	// Without it, ~/.ssh/config is only used for nodes that set ssh_host.
+	SSHConfig string `yaml:"ssh-config"`
+	// HostKeyPolicy controls SSH host key verification: insecure, strict or tofu
+	HostKeyPolicy string `yaml:"host-key-policy"`
+	// KnownHosts is the known_hosts file used by the strict and tofu policies
+	KnownHosts string `yaml:"known-hosts"`
+	// Manifests are local YAML files or directories uploaded to the primary
+	// server's manifests directory, which k3s applies automatically
+	Manifests []string `yaml:"manifests"`
+	// HelmCharts are turned into HelmChart/HelmChartConfig resources in the
+	// manifests directory and installed by the k3s helm controller
+	HelmCharts []HelmChart `yaml:"helm-charts"`
+	// SystemdRestartSec is the RestartSec of the k3s units, in seconds
+	SystemdRestartSec int `yaml:"systemd-restart-sec"`
+	// SystemdLimitNOFILE is the LimitNOFILE of the k3s units
+	SystemdLimitNOFILE int `yaml:"systemd-limit-nofile"`
+	// Environment is set in the k3s units, e.g. HTTP_PROXY for proxied networks
+	Environment map[string]string `yaml:"environment"`
+	// APIVIP is a virtual IP for the API server, announced by kube-vip on the
+	// servers. Nodes join and the kubeconfig points through it instead of the
+	// primary's own address.
+	APIVIP string `yaml:"api-vip"`
+	// APIVIPInterface is the interface kube-vip announces APIVIP on, the one
+	// of the default route when empty
+	APIVIPInterface string `yaml:"api-vip-interface"`
+	// InstallCLISymlinks links kubectl, crictl and ctr to the k3s binary on
+	// every node, true by default
+	InstallCLISymlinks *bool `yaml:"install-cli-symlinks"`
+	// SkipAgentKubectl leaves out the kubectl link on agents, which have no
+	// kubeconfig of their own
+	SkipAgentKubectl bool `yaml:"skip-agent-kubectl"`
+	// K3sVersion is the expected version of the k3s binary, such as
+	// v1.31.4+k3s1; a different uploaded binary is warned about. Assets left
+	// at their defaults are downloaded from this GitHub release.
+	K3sVersion string `yaml:"k3s-version"`
+	// KubeconfigServer is the host or host:port the downloaded kubeconfig
+	// points at, such as a load balancer's DNS name, instead of the API VIP
+	// or the primary's IP
+	KubeconfigServer string `yaml:"kubeconfig-server"`
+	// FixCgroups enables the memory cgroup on the kernel command line of
+	// nodes that lack it, such as Raspberry Pi OS; a reboot is needed
+	FixCgroups bool `yaml:"fix-cgroups"`
+	// DisableDefaultRegistryEndpoint stops containerd from falling back to
+	// the upstream registry when all mirror endpoints fail, for strict
+	// air-gapped installs
+	DisableDefaultRegistryEndpoint bool `yaml:"disable-default-registry-endpoint"`
+	// SystemDefaultRegistry is the host[:port] of a registry that all k3s
+	// system images are pulled from
+	SystemDefaultRegistry string `yaml:"system-default-registry"`
+	// ConfigYAML is uploaded to every node as /etc/rancher/k3s/config.yaml,
+	// for k3s options k3air has no field for. The flags k3air generates take
+	// precedence over it.
+	ConfigYAML K3sConfig `yaml:"config-yaml"`
+	// MetalLB deploys MetalLB for LoadBalancer services on bare metal, in
+	// place of the k3s servicelb
+	MetalLB *MetalLB `yaml:"metallb"`
+	// Storage picks the default StorageClass, moves the local-path volumes
+	// and installs Longhorn
+	Storage *Storage `yaml:"storage"`
+	// DisableTraefik adds traefik to Disable
+	DisableTraefik bool `yaml:"disable-traefik"`
+	// Ingress is the ingress controller: traefik (bundled with k3s), nginx
+	// or none; traefik unless it is disabled when empty
+	Ingress string `yaml:"ingress"`
+	// IngressNginx configures ingress-nginx when Ingress is nginx
+	IngressNginx *IngressNginx `yaml:"ingress-nginx"`
+	// ExtraImages are image archives, local paths or URLs, uploaded next to
+	// the airgap images on every node for k3s to import at startup
+	ExtraImages []string `yaml:"extra-images"`
+	// SSH restricts the algorithms of SSH connections to the nodes
+	SSH *SSH `yaml:"ssh"`
+}
+
+// SSH lists the ciphers, key exchanges and MACs offered to hardened hosts
+// that reject the defaults, or legacy ones that need older algorithms. An
+// empty list keeps the defaults.
+type SSH struct {
+	Ciphers []string `yaml:"ciphers"`
+	Kex     []string `yaml:"kex"`
+	MACs    []string `yaml:"macs"`
+}
+
+// Algorithms returns the SSH algorithms to offer; nil-safe
+func (s *SSH) Algorithms() sshclient.Algorithms {
+	if s == nil {
+		return sshclient.Algorithms{}
+	}
+	return sshclient.Algorithms{Ciphers: s.Ciphers, KeyExchanges: s.Kex, MACs: s.MACs}
+}
+
+// HelmChart describes a chart installed by the k3s helm controller. Chart is
+// a chart name in Repo, or the path of a local .tgz archive for air-gapped
+// installs. Without Chart, a HelmChartConfig is generated instead, which
+// overrides the values of a chart k3s ships itself (such as traefik).
+type HelmChart struct {
+	Name       string `yaml:"name"`
+	Repo       string `yaml:"repo"`
+	Chart      string `yaml:"chart"`
+	Version    string `yaml:"version"`
+	Namespace  string `yaml:"namespace"`
+	ValuesFile string `yaml:"values-file"`
 }
 
 type Node struct {
-	NodeName string   `yaml:"node_name"`
-	IP       string   `yaml:"ip"`
-	Port     int      `yaml:"port"`
-	User     string   `yaml:"user"`
-	Password string   `yaml:"password"`
-	KeyPath  string   `yaml:"key_path"`
-	Labels   []string `yaml:"labels"`
+	NodeName string `yaml:"node_name"`
+	IP       string `yaml:"ip"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// PasswordFile and PasswordEnv name a file or environment variable
+	// holding Password, keeping the secret out of the config
+	PasswordFile string   `yaml:"password_file"`
+	PasswordEnv  string   `yaml:"password_env"`
+	KeyPath      string   `yaml:"key_path"`
+	Labels       []string `yaml:"labels"`
+	// Taints are registered with the node as key=value:Effect
+	Taints []string `yaml:"taints"`
+	// NodeIP and NodeExternalIP are the addresses k3s registers the node
+	// with, for hosts whose SSH address is not on the cluster network. Each
+	// may be a comma separated IPv4/IPv6 dual-stack pair.
+	NodeIP         string `yaml:"node_ip"`
+	NodeExternalIP string `yaml:"node_external_ip"`
+	// ExtraArgs are appended verbatim to this node's k3s command line, after
+	// the cluster-wide extra args
+	ExtraArgs []string `yaml:"extra-args"`
+	// Sudo runs privileged commands through sudo when User is not root
+	Sudo bool `yaml:"sudo"`
+	// SSHHost is a Host alias from the SSH config to connect through, whose
+	// HostName, User, Port, IdentityFile and ProxyJump are used. IP stays the
+	// address the node has in the cluster.
+	SSHHost string `yaml:"ssh_host"`
+	// DataDir overrides the cluster data-dir on this node, for hosts with a
+	// different disk layout
+	DataDir string `yaml:"data_dir"`
+	// KubeletArgs are passed to the kubelet as --kubelet-arg, without
+	// leading dashes, e.g. max-pods=110
+	KubeletArgs []string `yaml:"kubelet-args"`
+	// SystemReserved and KubeReserved reserve resources for the system and
+	// the Kubernetes daemons, as resource=quantity pairs such as
+	// cpu=500m,memory=1Gi
+	SystemReserved string `yaml:"system-reserved"`
+	KubeReserved   string `yaml:"kube-reserved"`
+	// ConfigYAML overrides top-level keys of the cluster config-yaml on this
+	// node
+	ConfigYAML K3sConfig `yaml:"config-yaml"`
+	// Role is server or agent. It is required in the nodes list and
+	// optional in the servers and agents lists.
+	Role string `yaml:"role"`
+	// Primary marks the server that initializes the cluster
+	Primary bool `yaml:"primary"`
+	// Annotations are set on the node as key=value once it has joined, as
+	// the kubelet cannot register them
+	Annotations []string `yaml:"annotations"`
+	// PreInstallHooks run on the node before it is prepared for k3s, and
+	// PostInstallHooks once its k3s service is running
+	PreInstallHooks  []Hook `yaml:"pre-install-hooks"`
+	PostInstallHooks []Hook `yaml:"post-install-hooks"`
 }
 
 type Config struct {
@@ -40,22 +253,61 @@ type Config struct {
 	Assets  AssetSource `yaml:"assets"`
 	Servers []Node      `yaml:"servers"`
 	Agents  []Node      `yaml:"agents"`
+	// Nodes lists servers and agents together, each with a role and one
+	// primary server. Load sorts it into Servers and Agents.
+	Nodes []Node `yaml:"nodes"`
+
+	// assetsDir is the directory relative asset paths are relative to
+	assetsDir string
 }
 
+// defaultServiceCidr is the service CIDR used when none is configured
+const defaultServiceCidr = "10.43.0.0/16"
+
+// k3sVersionPattern matches a k3s release version such as v1.31.4+k3s1
+var k3sVersionPattern = regexp.MustCompile(`^v\d+\.\d+\.\d+(-rc\d+)?\+k3s\d+$`)
+
+// envNamePattern matches names that are valid environment variables
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Load reads, defaults and validates the config at path. Environment
+// variable references in values are expanded, with unset variables empty.
 func Load(path string) (Config, error) {
+	return LoadWithOptions(path, LoadOptions{})
+}
+
+// LoadWithOptions is Load with control over environment expansion
+func LoadWithOptions(path string, opts LoadOptions) (Config, error) {
+	return LoadFiles([]string{path}, opts)
+}
+
+// LoadFiles merges the config files at paths in order and loads the result
+// like LoadWithOptions: later files override the values of earlier ones,
+// except that the servers, agents and nodes lists are appended to. The
+// merged config is validated as a whole, and an auto-generated token is
+// kept next to the first file.
+func LoadFiles(paths []string, opts LoadOptions) (Config, error) {
 	var c Config
-	b, err := os.ReadFile(path)
+	if len(paths) == 0 {
+		return c, fmt.Errorf("no config file given")
+	}
+	doc, err := readDocs(paths, opts)
 	if err != nil {
 		return c, err
 	}
-	if err := yaml.Unmarshal(b, &c); err != nil {
-		return c, err
+	if len(doc.Content) > 0 {
+		if err := doc.Decode(&c); err != nil {
+			return c, err
+		}
+	}
+	if err := c.assignRoles(); err != nil {
+		return c, fmt.Errorf("config validation failed: %w", err)
 	}
 	if c.Cluster.ClusterCidr == "" {
 		c.Cluster.ClusterCidr = "10.42.0.0/16"
 	}
 	if c.Cluster.ServiceCidr == "" {
-		c.Cluster.ServiceCidr = "10.43.0.0/16"
+		c.Cluster.ServiceCidr = defaultServiceCidr
 	}
 	if c.Cluster.DataDir == "" {
 		c.Cluster.DataDir = "/var/lib/rancher/k3s"
@@ -63,11 +315,24 @@ func Load(path string) (Config, error) {
 	if c.Cluster.FlannelBackend == "" {
 		c.Cluster.FlannelBackend = "vxlan"
 	}
-	if c.Assets.K3sBinary == "" {
-		c.Assets.K3sBinary = "k3s"
+	if c.Cluster.KubeconfigPath == "" {
+		c.Cluster.KubeconfigPath = "kubeconfig"
+	}
+	if c.Cluster.HostKeyPolicy == "" {
+		c.Cluster.HostKeyPolicy = "insecure"
+	}
+	if c.Cluster.SystemdRestartSec == 0 {
+		c.Cluster.SystemdRestartSec = 5
 	}
-	if c.Assets.K3sAirgapTarball == "" {
-		c.Assets.K3sAirgapTarball = "k3s-airgap-images-amd64.tar.gz"
+	if c.Cluster.SystemdLimitNOFILE == 0 {
+		c.Cluster.SystemdLimitNOFILE = 1048576
+	}
+	if c.Cluster.InstallCLISymlinks == nil {
+		enabled := true
+		c.Cluster.InstallCLISymlinks = &enabled
+	}
+	if c.Cluster.DisableTraefik && !slices.Contains(c.Cluster.Disable, "traefik") {
+		c.Cluster.Disable = append(c.Cluster.Disable, "traefik")
 	}
 	// Set default port to 22 if not specified
 	for i := range c.Servers {
@@ -80,49 +345,727 @@ func Load(path string) (Config, error) {
 			c.Agents[i].Port = 22
 		}
 	}
+	if err := c.resolveAssetPaths(filepath.Dir(paths[0]), opts.AssetsDir); err != nil {
+		return c, fmt.Errorf("config validation failed: %w", err)
+	}
+	if err := c.resolveSecrets(); err != nil {
+		return c, fmt.Errorf("config validation failed: %w", err)
+	}
+	if opts.PromptPasswords {
+		if err := c.promptPasswords(); err != nil {
+			return c, err
+		}
+	}
 	if err := c.Validate(); err != nil {
 		return c, fmt.Errorf("config validation failed: %w", err)
 	}
+	if c.Cluster.Token == "" {
+		token, err := readGeneratedToken(TokenFilePath(paths[0]))
+		if err == nil && token == "" && opts.GenerateToken {
+			token, err = generateToken(TokenFilePath(paths[0]))
+		}
+		if err != nil {
+			return c, err
+		}
+		c.Cluster.Token = token
+	}
 	return c, nil
 }
 
+// TokenFilePath returns the sidecar file used to persist an auto-generated
+// cluster token for the config file at configPath
+func TokenFilePath(configPath string) string {
+	return configPath + ".token"
+}
+
+// readGeneratedToken returns the token stored in tokenPath, or "" if the
+// file does not exist yet
+func readGeneratedToken(tokenPath string) (string, error) {
+	b, err := os.ReadFile(tokenPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token != "" {
+		slog.Debug("using persisted cluster token", "path", tokenPath)
+	}
+	return token, nil
+}
+
+// generateToken generates a random cluster token and persists it in
+// tokenPath
+func generateToken(tokenPath string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate cluster token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+	if err := os.WriteFile(tokenPath, []byte(token+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to persist cluster token: %w", err)
+	}
+	slog.Info("cluster token not set, generated a random token", "saved to", tokenPath)
+	return token, nil
+}
+
 // Validate validates the configuration
 func (c *Config) Validate() error {
-	// Validate CIDR formats
-	clusterCIDR, err := parseAndValidateCIDR(c.Cluster.ClusterCidr, "cluster-cidr")
+	if len(c.Servers) == 0 {
+		return fmt.Errorf("no servers defined: at least one entry under servers is required (the first one becomes the primary)")
+	}
+	if !c.hasCredentials(c.Servers[0]) {
+		return fmt.Errorf("primary server %s (%s) has no usable SSH credentials: set password or key_path, or add a key to the ssh-agent (SSH_AUTH_SOCK)",
+			c.Servers[0].NodeName, c.Servers[0].IP)
+	}
+
+	// Validate CIDR formats; each field may hold one CIDR or an IPv4/IPv6 dual-stack pair
+	clusterCIDRs, err := parseAndValidateCIDRs(c.Cluster.ClusterCidr, "cluster-cidr")
 	if err != nil {
 		return err
 	}
-	serviceCIDR, err := parseAndValidateCIDR(c.Cluster.ServiceCidr, "service-cidr")
+	serviceCIDRs, err := parseAndValidateCIDRs(c.Cluster.ServiceCidr, "service-cidr")
 	if err != nil {
 		return err
 	}
+	if len(clusterCIDRs) != len(serviceCIDRs) {
+		return fmt.Errorf("cluster-cidr (%s) and service-cidr (%s) must both be single-stack or both be dual-stack",
+			c.Cluster.ClusterCidr, c.Cluster.ServiceCidr)
+	}
+
+	// Compare the CIDRs of each address family with each other
+	for _, clusterCIDR := range clusterCIDRs {
+		serviceCIDR := sameFamily(clusterCIDR, serviceCIDRs)
+		if serviceCIDR == nil {
+			return fmt.Errorf("cluster-cidr %s has no service-cidr of the same address family (service-cidr: %s)",
+				clusterCIDR, c.Cluster.ServiceCidr)
+		}
+
+		// Check if CIDRs are identical
+		if cidrsEqual(clusterCIDR, serviceCIDR) {
+			return fmt.Errorf("cluster-cidr and service-cidr cannot be the same: %s", clusterCIDR)
+		}
+
+		// Check if CIDRs overlap
+		if cidrsOverlap(clusterCIDR, serviceCIDR) {
+			return fmt.Errorf("cluster-cidr (%s) and service-cidr (%s) overlap", clusterCIDR, serviceCIDR)
+		}
+	}
+
+	if err := c.validateClusterDNS(serviceCIDRs); err != nil {
+		return err
+	}
+	if err := c.validateMetalLB(clusterCIDRs, serviceCIDRs); err != nil {
+		return err
+	}
+
+	if err := validateFlannelBackend(c.Cluster.FlannelBackend); err != nil {
+		return err
+	}
+	if err := validateDisable(c.Cluster.Disable); err != nil {
+		return err
+	}
+	if err := validateIngress(c.Cluster); err != nil {
+		return err
+	}
+	if err := validateExtraImages(c.Cluster); err != nil {
+		return err
+	}
+
+	if c.Cluster.DatastoreEndpoint != "" {
+		if err := validateDatastoreEndpoint(c.Cluster.DatastoreEndpoint); err != nil {
+			return err
+		}
+	} else if n := len(c.Servers); n > 1 && n%2 == 0 {
+		// etcd needs a majority: an even member count tolerates no more
+		// failures than one member less
+		slog.Warn("even number of servers with embedded etcd: use an odd number (3, 5, 7) for quorum", "servers", n)
+	}
+
+	if c.Assets.Proxy != "" {
+		if err := validateProxy(c.Assets.Proxy); err != nil {
+			return err
+		}
+	}
+	if (c.Assets.K3sBinarySig == "") != (c.Assets.SigningKey == "") {
+		return fmt.Errorf("assets.k3s-binary-sig and assets.signing-key must be set together")
+	}
+	if isURLPath(c.Assets.CAFile) {
+		return fmt.Errorf("assets.ca-file must be a local file: %s", c.Assets.CAFile)
+	}
+	if c.Assets.CAFile != "" && c.Assets.InsecureSkipTLSVerify {
+		return fmt.Errorf("assets.ca-file and assets.insecure-skip-tls-verify cannot be combined: remove insecure-skip-tls-verify to verify against the CA")
+	}
+	if isURLPath(c.Assets.SigningKey) {
+		return fmt.Errorf("assets.signing-key must be a local file, a downloaded key would not protect anything: %s", c.Assets.SigningKey)
+	}
 
-	// Check if CIDRs are identical
-	if cidrsEqual(clusterCIDR, serviceCIDR) {
-		return fmt.Errorf("cluster-cidr and service-cidr cannot be the same: %s", c.Cluster.ClusterCidr)
+	if v := c.Cluster.K3sVersion; v != "" && !k3sVersionPattern.MatchString(v) {
+		return fmt.Errorf("invalid k3s-version: %s (expected a release such as v1.31.4+k3s1)", v)
 	}
 
-	// Check if CIDRs overlap
-	if cidrsOverlap(clusterCIDR, serviceCIDR) {
-		return fmt.Errorf("cluster-cidr (%s) and service-cidr (%s) overlap", c.Cluster.ClusterCidr, c.Cluster.ServiceCidr)
+	switch c.Cluster.HostKeyPolicy {
+	case "insecure", "strict", "tofu":
+	default:
+		return fmt.Errorf("invalid host-key-policy: %s (valid options: insecure, strict, tofu)", c.Cluster.HostKeyPolicy)
+	}
+	if err := c.Cluster.SSH.Algorithms().Check(); err != nil {
+		return fmt.Errorf("ssh: %w", err)
 	}
 
-	// Validate node IPs
+	// Validate node IPs, taints, labels and annotations
 	for _, node := range c.Servers {
-		if err := validateNodeIP(node); err != nil {
-			return fmt.Errorf("server %s: %w", node.NodeName, err)
+		if err := validateNode("server", node); err != nil {
+			return err
 		}
 	}
 	for _, node := range c.Agents {
-		if err := validateNodeIP(node); err != nil {
-			return fmt.Errorf("agent %s: %w", node.NodeName, err)
+		if err := validateNode("agent", node); err != nil {
+			return err
+		}
+	}
+
+	if err := c.validateUniqueNodes(); err != nil {
+		return err
+	}
+	if err := c.validateAPIVIP(); err != nil {
+		return err
+	}
+	if err := c.ValidateKubeconfigServer(); err != nil {
+		return err
+	}
+
+	if c.Cluster.SystemdRestartSec < 0 {
+		return fmt.Errorf("systemd-restart-sec must not be negative: %d", c.Cluster.SystemdRestartSec)
+	}
+	if c.Cluster.SystemdLimitNOFILE < 0 {
+		return fmt.Errorf("systemd-limit-nofile must not be negative: %d", c.Cluster.SystemdLimitNOFILE)
+	}
+	for name := range c.Cluster.Environment {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name: %q", name)
+		}
+	}
+
+	if err := validateRegistries(c.Cluster); err != nil {
+		return err
+	}
+	if err := validateSystemDefaultRegistry(c.Cluster.SystemDefaultRegistry); err != nil {
+		return err
+	}
+	if err := validateK3sConfig("cluster", c.Cluster.ConfigYAML); err != nil {
+		return err
+	}
+	if err := validateManifests(c.Cluster.Manifests); err != nil {
+		return err
+	}
+	if err := validateStorage(c.Cluster); err != nil {
+		return err
+	}
+	if err := validateHelmCharts(c.Cluster.Charts()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// domainPattern matches a DNS domain name such as cluster.local
+var domainPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// validateClusterDNS checks that every cluster-dns IP lies in the service
+// CIDR of its address family, and that cluster-domain is a domain name
+func (c *Config) validateClusterDNS(serviceCIDRs []*net.IPNet) error {
+	if c.Cluster.ClusterDNS == "" {
+		if c.Cluster.ServiceCidr != defaultServiceCidr {
+			slog.Warn("service-cidr is customized but cluster-dns is not set: k3s will use the .10 address of the service CIDR", "service-cidr", c.Cluster.ServiceCidr)
 		}
+	} else {
+		for _, s := range strings.Split(c.Cluster.ClusterDNS, ",") {
+			s = strings.TrimSpace(s)
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return fmt.Errorf("invalid cluster-dns: %q is not an ip address", s)
+			}
+			inside := false
+			for _, cidr := range serviceCIDRs {
+				if cidr.Contains(ip) {
+					inside = true
+				}
+			}
+			if !inside {
+				return fmt.Errorf("cluster-dns %s is not inside service-cidr %s", s, c.Cluster.ServiceCidr)
+			}
+		}
+	}
+	if c.Cluster.ClusterDomain != "" && !domainPattern.MatchString(c.Cluster.ClusterDomain) {
+		return fmt.Errorf("invalid cluster-domain: %q", c.Cluster.ClusterDomain)
 	}
+	return nil
+}
 
+// validateAPIVIP checks that api-vip is an IP that no node already uses
+func (c *Config) validateAPIVIP() error {
+	vip := c.Cluster.APIVIP
+	if vip == "" {
+		return nil
+	}
+	if net.ParseIP(vip) == nil {
+		return fmt.Errorf("invalid api-vip: %q is not an ip address", vip)
+	}
+	for _, node := range append(append([]Node{}, c.Servers...), c.Agents...) {
+		addrs := []string{node.IP}
+		for _, s := range strings.Split(node.NodeIP+","+node.NodeExternalIP, ",") {
+			addrs = append(addrs, strings.TrimSpace(s))
+		}
+		for _, addr := range addrs {
+			if addr != "" && net.ParseIP(addr).Equal(net.ParseIP(vip)) {
+				return fmt.Errorf("api-vip %s is already assigned to node %s", vip, node.NodeName)
+			}
+		}
+	}
 	return nil
 }
 
+// ValidateKubeconfigServer checks that kubeconfig-server is a host or
+// host:port, and warns when the host is missing from the API server
+// certificate, which kubectl would then reject
+func (c *Config) ValidateKubeconfigServer() error {
+	server := c.Cluster.KubeconfigServer
+	if server == "" {
+		return nil
+	}
+	if strings.ContainsAny(server, "/ ") {
+		return fmt.Errorf("invalid kubeconfig-server %q: must be a host or host:port, without scheme", server)
+	}
+	host := server
+	if h, port, err := net.SplitHostPort(server); err == nil {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("invalid kubeconfig-server %q: bad port %q", server, port)
+		}
+		host = h
+	}
+	if host == "" {
+		return fmt.Errorf("invalid kubeconfig-server %q: missing host", server)
+	}
+	sans := append([]string{c.Cluster.APIVIP, "127.0.0.1", "localhost"}, c.Cluster.TLSSAN...)
+	for _, srv := range c.Servers {
+		sans = append(sans, srv.IP)
+	}
+	if !slices.Contains(sans, host) {
+		slog.Warn("kubeconfig-server is not in tls-san, kubectl will reject the API server certificate: add it to cluster.tls-san", "host", host)
+	}
+	return nil
+}
+
+// disableableComponents lists the packaged components k3s can --disable
+var disableableComponents = []string{"traefik", "servicelb", "metrics-server", "local-storage", "coredns", "runtimes"}
+
+// validateDisable checks every disable entry against the components k3s
+// knows, as k3s silently ignores a misspelled one
+func validateDisable(disable []string) error {
+	for _, d := range disable {
+		if d != "" && !slices.Contains(disableableComponents, d) {
+			return fmt.Errorf("invalid disable entry %q: must be one of %s", d, strings.Join(disableableComponents, ", "))
+		}
+	}
+	return nil
+}
+
+// flannelBackends lists the flannel backends supported by k3s
+var flannelBackends = []string{"vxlan", "host-gw", "wireguard-native", "ipsec", "none"}
+
+// validateFlannelBackend checks backend against the supported set
+func validateFlannelBackend(backend string) error {
+	for _, b := range flannelBackends {
+		if backend == b {
+			if backend == "none" {
+				slog.Warn("flannel-backend is none: a CNI plugin must be installed separately or pods will not get networking")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid flannel-backend: %s (valid options: %s)", backend, strings.Join(flannelBackends, ", "))
+}
+
+// datastoreSchemes lists the external datastore drivers supported by k3s
+var datastoreSchemes = []string{"mysql", "postgres", "postgresql", "http", "https"}
+
+// validateDatastoreEndpoint checks that endpoint uses a supported driver.
+// etcd endpoints may be a comma separated list of http(s) URLs.
+func validateDatastoreEndpoint(endpoint string) error {
+	for _, ep := range strings.Split(endpoint, ",") {
+		scheme, _, ok := strings.Cut(strings.TrimSpace(ep), "://")
+		if !ok {
+			return fmt.Errorf("invalid datastore-endpoint: missing scheme (valid schemes: %s)", strings.Join(datastoreSchemes, ", "))
+		}
+		supported := false
+		for _, s := range datastoreSchemes {
+			if scheme == s {
+				supported = true
+			}
+		}
+		if !supported {
+			return fmt.Errorf("invalid datastore-endpoint: unsupported scheme %q (valid schemes: %s)", scheme, strings.Join(datastoreSchemes, ", "))
+		}
+	}
+	return nil
+}
+
+// validateProxy checks that proxy is an absolute http, https or socks5 URL
+func validateProxy(proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return fmt.Errorf("invalid assets.proxy: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("invalid assets.proxy %s: scheme must be http, https or socks5", u.Redacted())
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid assets.proxy %s: missing host", u.Redacted())
+	}
+	return nil
+}
+
+// validateTaints checks that every taint has the form key[=value]:Effect
+func validateTaints(node Node) error {
+	for _, t := range node.Taints {
+		kv, effect, ok := strings.Cut(t, ":")
+		if !ok {
+			return fmt.Errorf("invalid taint %q: expected key=value:Effect", t)
+		}
+		key, _, _ := strings.Cut(kv, "=")
+		if key == "" {
+			return fmt.Errorf("invalid taint %q: key is empty", t)
+		}
+		switch effect {
+		case "NoSchedule", "PreferNoSchedule", "NoExecute":
+		default:
+			return fmt.Errorf("invalid taint %q: effect must be one of NoSchedule, PreferNoSchedule, NoExecute", t)
+		}
+	}
+	return nil
+}
+
+// validateDataDir checks that a node data_dir override is an absolute path
+func validateDataDir(node Node) error {
+	if node.DataDir != "" && !path.IsAbs(node.DataDir) {
+		return fmt.Errorf("data_dir must be an absolute path: %s", node.DataDir)
+	}
+	return nil
+}
+
+// DataDir returns the k3s data directory of node: its data_dir override, or
+// the cluster data-dir when unset
+func (c *Config) DataDir(node Node) string {
+	if node.DataDir != "" {
+		return node.DataDir
+	}
+	return c.Cluster.DataDir
+}
+
+// hasCredentials reports whether node can authenticate over SSH with a
+// password, a private key, an ssh-agent holding a key or the SSH config
+func (c *Config) hasCredentials(node Node) bool {
+	if node.Password != "" || node.KeyPath != "" || sshclient.AgentHasKeys() {
+		return true
+	}
+	// An IdentityFile from the SSH config may authenticate the node
+	return node.SSHHost != "" || c.Cluster.SSHConfig != ""
+}
+
+// validateUniqueNodes rejects node names and IPs that are used more than
+// once across servers and agents
+func (c *Config) validateUniqueNodes() error {
+	names := make(map[string]string)
+	ips := make(map[string]string)
+	check := func(role string, idx int, node Node) error {
+		desc := fmt.Sprintf("%s[%d] (node_name=%q, ip=%s)", role, idx, node.NodeName, node.IP)
+		if node.NodeName != "" {
+			if prev, ok := names[node.NodeName]; ok {
+				return fmt.Errorf("duplicate node_name %q: %s conflicts with %s", node.NodeName, desc, prev)
+			}
+			names[node.NodeName] = desc
+		}
+		// Normalize so that e.g. IPv6 spellings of the same address collide
+		ip := net.ParseIP(node.IP).String()
+		if prev, ok := ips[ip]; ok {
+			return fmt.Errorf("duplicate ip %s: %s conflicts with %s", node.IP, desc, prev)
+		}
+		ips[ip] = desc
+		return nil
+	}
+	for idx, node := range c.Servers {
+		if err := check("servers", idx, node); err != nil {
+			return err
+		}
+	}
+	for idx, node := range c.Agents {
+		if err := check("agents", idx, node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindNode looks up a configured node by node_name or IP. isServer reports
+// whether it is a server; the primary is always Servers[0].
+func (c *Config) FindNode(nameOrIP string) (node Node, isServer bool, err error) {
+	ip := net.ParseIP(nameOrIP)
+	match := func(n Node) bool {
+		if n.NodeName != "" && n.NodeName == nameOrIP {
+			return true
+		}
+		return ip != nil && ip.Equal(net.ParseIP(n.IP))
+	}
+	for _, n := range c.Servers {
+		if match(n) {
+			return n, true, nil
+		}
+	}
+	for _, n := range c.Agents {
+		if match(n) {
+			return n, false, nil
+		}
+	}
+	return Node{}, false, fmt.Errorf("node %s is not in the config: use the node_name or ip of a configured server or agent", nameOrIP)
+}
+
+// ValidateKubeconfigFrom checks that nameOrIP is a configured server, which
+// the kubeconfig can be downloaded from
+func (c *Config) ValidateKubeconfigFrom(nameOrIP string) error {
+	_, isServer, err := c.FindNode(nameOrIP)
+	if err != nil {
+		return err
+	}
+	if !isServer {
+		return fmt.Errorf("node %s is an agent: the kubeconfig can only be downloaded from a server", nameOrIP)
+	}
+	return nil
+}
+
+// SelectNodes returns the configured servers and agents narrowed down to the
+// nodes in only, when it is not empty, minus the nodes in skip. Nodes are
+// given by node_name or ip; unknown ones are an error.
+func (c *Config) SelectNodes(only, skip []string) (servers, agents []Node, err error) {
+	selected := func(list []string) (map[string]bool, error) {
+		ips := make(map[string]bool, len(list))
+		for _, s := range list {
+			n, _, err := c.FindNode(s)
+			if err != nil {
+				return nil, err
+			}
+			ips[n.IP] = true
+		}
+		return ips, nil
+	}
+	onlyIPs, err := selected(only)
+	if err != nil {
+		return nil, nil, err
+	}
+	skipIPs, err := selected(skip)
+	if err != nil {
+		return nil, nil, err
+	}
+	keep := func(n Node) bool {
+		return (len(only) == 0 || onlyIPs[n.IP]) && !skipIPs[n.IP]
+	}
+	for _, n := range c.Servers {
+		if keep(n) {
+			servers = append(servers, n)
+		}
+	}
+	for _, n := range c.Agents {
+		if keep(n) {
+			agents = append(agents, n)
+		}
+	}
+	if len(servers)+len(agents) == 0 {
+		return nil, nil, fmt.Errorf("no nodes left to install after applying --only and --skip")
+	}
+	return servers, agents, nil
+}
+
+// validateNode runs the checks every node gets, role naming it in errors
+func validateNode(role string, node Node) error {
+	checks := []func(Node) error{
+		validateNodeIP,
+		validateNodeAddresses,
+		validateTaints,
+		validateMetadata,
+		validateHooks,
+		validateDataDir,
+		validateKubelet,
+		func(n Node) error { return validateK3sConfig(n.NodeName, n.ConfigYAML) },
+	}
+	for _, check := range checks {
+		if err := check(node); err != nil {
+			return fmt.Errorf("%s %s: %w", role, node.NodeName, err)
+		}
+	}
+	return nil
+}
+
+// ValidateNewAgents validates agents that are about to join the existing
+// cluster: each must have a valid IP and must not clash with a configured node
+func (c *Config) ValidateNewAgents(nodes []Node) error {
+	if len(nodes) == 0 {
+		return fmt.Errorf("no agents specified")
+	}
+	existing := make(map[string]bool)
+	for _, n := range append(append([]Node{}, c.Servers...), c.Agents...) {
+		existing[n.IP] = true
+		if n.NodeName != "" {
+			existing[n.NodeName] = true
+		}
+	}
+	for _, node := range nodes {
+		if err := validateNode("agent", node); err != nil {
+			return err
+		}
+		if existing[node.IP] {
+			return fmt.Errorf("agent %s: ip %s is already part of the cluster", node.NodeName, node.IP)
+		}
+		if node.NodeName != "" && existing[node.NodeName] {
+			return fmt.Errorf("agent %s: node name is already part of the cluster", node.NodeName)
+		}
+		if node.KeyPath != "" {
+			if _, err := os.Stat(node.KeyPath); err != nil {
+				return fmt.Errorf("agent %s: key_path: %w", node.NodeName, err)
+			}
+		}
+		existing[node.IP] = true
+		if node.NodeName != "" {
+			existing[node.NodeName] = true
+		}
+	}
+	return nil
+}
+
+// ParseNodeSpec parses a node given on the command line as a comma separated
+// list of key=value pairs, e.g. "name=agent-1,ip=10.0.0.5,user=root,password=secret".
+// Supported keys are name, ip, port, user, password, password_file,
+// password_env, key_path, label, annotation, taint (these three may be
+// repeated), node_ip, node_external_ip, ssh_host and data_dir.
+func ParseNodeSpec(spec string) (Node, error) {
+	var n Node
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return n, fmt.Errorf("invalid node field %q (expected key=value)", field)
+		}
+		switch key {
+		case "name", "node_name":
+			n.NodeName = value
+		case "ip":
+			n.IP = value
+		case "port":
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return n, fmt.Errorf("invalid port %q: %w", value, err)
+			}
+			n.Port = port
+		case "user":
+			n.User = value
+		case "password":
+			n.Password = value
+		case "password_file":
+			n.PasswordFile = value
+		case "password_env":
+			n.PasswordEnv = value
+		case "key_path":
+			n.KeyPath = value
+		case "label":
+			n.Labels = append(n.Labels, value)
+		case "annotation":
+			n.Annotations = append(n.Annotations, value)
+		case "taint":
+			n.Taints = append(n.Taints, value)
+		case "node_ip":
+			n.NodeIP = value
+		case "node_external_ip":
+			n.NodeExternalIP = value
+		case "ssh_host":
+			n.SSHHost = value
+		case "data_dir":
+			n.DataDir = value
+		default:
+			return n, fmt.Errorf("unknown node field %q", key)
+		}
+	}
+	if n.Port == 0 {
+		n.Port = 22
+	}
+	if err := resolveNodePassword(&n); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// LoadNodes reads a YAML file containing an "agents" list in the same format
+// as the main config file
+func LoadNodes(path string) ([]Node, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f struct {
+		Agents []Node `yaml:"agents"`
+	}
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	if err := checkKnownFields(b, &f); err != nil {
+		return nil, err
+	}
+	for i := range f.Agents {
+		if f.Agents[i].Port == 0 {
+			f.Agents[i].Port = 22
+		}
+		if f.Agents[i].Role != "" && f.Agents[i].Role != RoleAgent || f.Agents[i].Primary {
+			return nil, fmt.Errorf("agents[%d] (%s): only agents can be added", i, f.Agents[i].IP)
+		}
+		f.Agents[i].Role = RoleAgent
+		if err := resolveNodePaths(filepath.Dir(path), &f.Agents[i]); err != nil {
+			return nil, fmt.Errorf("agents[%d] (%s): %w", i, f.Agents[i].IP, err)
+		}
+		if err := resolveNodePassword(&f.Agents[i]); err != nil {
+			return nil, fmt.Errorf("agents[%d] (%s): %w", i, f.Agents[i].IP, err)
+		}
+	}
+	return f.Agents, nil
+}
+
+// checkKnownFields fails on keys in b that no field of v reads, typically
+// misspelled ones, which decoding would otherwise silently drop. The raw,
+// unexpanded document is decoded, so only unknown fields are reported here:
+// values that only get their type after environment expansion, such as
+// port: ${SSH_PORT}, are checked by the real decode.
+func checkKnownFields(b []byte, v any) error {
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	var typeErr *yaml.TypeError
+	if err := dec.Decode(v); !errors.As(err, &typeErr) {
+		return nil
+	}
+	var unknown []string
+	for _, e := range typeErr.Errors {
+		if strings.Contains(e, "not found in type") {
+			unknown = append(unknown, e)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	return fmt.Errorf("unknown config field(s), check for typos:\n  %s", strings.Join(unknown, "\n  "))
+}
+
 // parseAndValidateCIDR parses and validates a CIDR string
 func parseAndValidateCIDR(cidrStr, fieldName string) (*net.IPNet, error) {
 	_, cidr, err := net.ParseCIDR(cidrStr)
@@ -132,6 +1075,41 @@ func parseAndValidateCIDR(cidrStr, fieldName string) (*net.IPNet, error) {
 	return cidr, nil
 }
 
+// parseAndValidateCIDRs parses a comma separated list holding a single CIDR
+// or a dual-stack pair with one IPv4 and one IPv6 CIDR
+func parseAndValidateCIDRs(cidrStr, fieldName string) ([]*net.IPNet, error) {
+	var cidrs []*net.IPNet
+	for _, part := range strings.Split(cidrStr, ",") {
+		cidr, err := parseAndValidateCIDR(strings.TrimSpace(part), fieldName)
+		if err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	switch {
+	case len(cidrs) > 2:
+		return nil, fmt.Errorf("invalid %s: %s (at most one IPv4 and one IPv6 CIDR are allowed)", fieldName, cidrStr)
+	case len(cidrs) == 2 && isIPv4(cidrs[0]) == isIPv4(cidrs[1]):
+		return nil, fmt.Errorf("invalid %s: %s (dual-stack requires one IPv4 and one IPv6 CIDR)", fieldName, cidrStr)
+	}
+	return cidrs, nil
+}
+
+// isIPv4 reports whether cidr is an IPv4 network
+func isIPv4(cidr *net.IPNet) bool {
+	return cidr.IP.To4() != nil
+}
+
+// sameFamily returns the CIDR in candidates with the same address family as cidr
+func sameFamily(cidr *net.IPNet, candidates []*net.IPNet) *net.IPNet {
+	for _, c := range candidates {
+		if isIPv4(c) == isIPv4(cidr) {
+			return c
+		}
+	}
+	return nil
+}
+
 // cidrsEqual checks if two CIDRs are exactly the same
 func cidrsEqual(a, b *net.IPNet) bool {
 	return a.IP.Equal(b.IP) && bytesEqual(a.Mask, b.Mask)
@@ -166,3 +1144,23 @@ func validateNodeIP(node Node) error {
 	}
 	return nil
 }
+
+// validateNodeAddresses checks node_ip and node_external_ip, which hold one
+// IP or a comma separated dual-stack pair
+func validateNodeAddresses(node Node) error {
+	fields := []struct{ name, value string }{
+		{"node_ip", node.NodeIP},
+		{"node_external_ip", node.NodeExternalIP},
+	}
+	for _, f := range fields {
+		if f.value == "" {
+			continue
+		}
+		for _, s := range strings.Split(f.value, ",") {
+			if net.ParseIP(strings.TrimSpace(s)) == nil {
+				return fmt.Errorf("invalid %s: %q is not an ip address", f.name, s)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// writeConfig writes content to a config file in a new temporary directory
+// and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "init.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadAgentsOnly(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	path := writeConfig(t, `
+cluster:
+  token: test-token
+agents:
+  - ip: 192.0.2.10
+    password: secret
+`)
+	_, err := LoadWithOptions(path, LoadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "no servers defined") {
+		t.Fatalf("got error %v, want the no servers defined error", err)
+	}
+}
+
+// serveAgent runs an ssh-agent holding keyring on a socket in a temporary
+// directory and points SSH_AUTH_SOCK at it
+func serveAgent(t *testing.T, keyring agent.Agent) {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+}
+
+func TestHasCredentialsAgent(t *testing.T) {
+	keyring := agent.NewKeyring()
+	serveAgent(t, keyring)
+	c := &Config{}
+	node := Node{IP: "192.0.2.10"}
+	if c.hasCredentials(node) {
+		t.Error("an ssh-agent without keys counts as credentials")
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.hasCredentials(node) {
+		t.Error("an ssh-agent holding a key does not count as credentials")
+	}
+}
+
+func TestLoadGeneratesTokenOnlyWhenAsked(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	path := writeConfig(t, `
+servers:
+  - ip: 192.0.2.10
+    password: secret
+`)
+	cfg, err := LoadWithOptions(path, LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Cluster.Token != "" {
+		t.Errorf("got token %q without GenerateToken", cfg.Cluster.Token)
+	}
+	if _, err := os.Stat(TokenFilePath(path)); !os.IsNotExist(err) {
+		t.Fatalf("token file written without GenerateToken: %v", err)
+	}
+
+	generated, err := LoadWithOptions(path, LoadOptions{GenerateToken: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generated.Cluster.Token == "" {
+		t.Fatal("no token generated")
+	}
+	cfg, err = LoadWithOptions(path, LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Cluster.Token != generated.Cluster.Token {
+		t.Errorf("got token %q, want the generated %q", cfg.Cluster.Token, generated.Cluster.Token)
+	}
+}
+
+func TestCheckKnownFields(t *testing.T) {
+	err := checkKnownFields([]byte(`
+cluster:
+  serivce-cidr: 10.43.0.0/16
+servers:
+  - ip: 192.0.2.10
+    port: ${SSH_PORT}
+`), &Config{})
+	if err == nil {
+		t.Fatal("misspelled serivce-cidr not reported")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "serivce-cidr") || strings.Contains(msg, "port") {
+		t.Errorf("got error %q, want only serivce-cidr reported", msg)
+	}
+
+	err = checkKnownFields([]byte(`
+servers:
+  - ip: 192.0.2.10
+    port: ${SSH_PORT}
+`), &Config{})
+	if err != nil {
+		t.Errorf("port: ${SSH_PORT} rejected before expansion: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadOptions controls how a config file is loaded
+type LoadOptions struct {
+	// StrictEnv makes references to unset environment variables an error
+	// instead of expanding them to an empty string
+	StrictEnv bool
+	// PromptPasswords asks for the SSH password of nodes without any
+	// credentials when stdin is a terminal
+	PromptPasswords bool
+	// AssetsDir is the directory relative paths in the assets section are
+	// relative to, instead of the directory of the config file
+	AssetsDir string
+	// GenerateToken generates a cluster token when the config sets none and
+	// none was generated before, saving it next to the first config file.
+	// Only apply sets it: the other commands use the token of the cluster.
+	GenerateToken bool
+}
+
+// expandEnv expands ${VAR} and $VAR references in every scalar value of the
+// YAML document. Keys, comments and hook commands are left alone, and $$
+// yields a literal $.
+// Expanded values are never parsed as YAML again, so secrets containing YAML
+// syntax are safe; plain scalars are re-typed so e.g. port: ${SSH_PORT} works.
+func expandEnv(doc *yaml.Node, strict bool) error {
+	missing := make(map[string]bool)
+	mapping := func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing[name] = true
+		}
+		return v
+	}
+
+	var walk func(n *yaml.Node, isKey bool)
+	walk = func(n *yaml.Node, isKey bool) {
+		switch n.Kind {
+		case yaml.ScalarNode:
+			if isKey || !strings.Contains(n.Value, "$") {
+				return
+			}
+			n.Value = os.Expand(n.Value, mapping)
+			if n.Style == 0 {
+				n.Tag = ""
+			}
+		case yaml.MappingNode:
+			for idx, child := range n.Content {
+				if idx%2 == 1 && isHookKey(n.Content[idx-1].Value) {
+					walkHooks(child, walk)
+					continue
+				}
+				walk(child, idx%2 == 0)
+			}
+		default:
+			for _, child := range n.Content {
+				walk(child, false)
+			}
+		}
+	}
+	walk(doc, false)
+
+	if len(missing) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(missing))
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if strict {
+		return fmt.Errorf("config references unset environment variable(s): %s", strings.Join(names, ", "))
+	}
+	for _, name := range names {
+		slog.Warn("config references unset environment variable, using an empty value", "variable", name)
+	}
+	return nil
+}
+
+// isHookKey reports whether key holds a node's install hooks
+func isHookKey(key string) bool {
+	return key == "pre-install-hooks" || key == "post-install-hooks"
+}
+
+// walkHooks walks a hook list, skipping the commands: they are shell run on
+// the node, where $HOSTNAME, $1 or $? must reach the shell unexpanded
+func walkHooks(n *yaml.Node, walk func(n *yaml.Node, isKey bool)) {
+	if n.Kind != yaml.SequenceNode {
+		walk(n, false)
+		return
+	}
+	for _, hook := range n.Content {
+		switch hook.Kind {
+		case yaml.ScalarNode:
+			// A command string
+		case yaml.MappingNode:
+			for idx := 1; idx < len(hook.Content); idx += 2 {
+				if hook.Content[idx-1].Value != "command" {
+					walk(hook.Content[idx], false)
+				}
+			}
+		default:
+			walk(hook, false)
+		}
+	}
+}
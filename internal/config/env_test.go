@@ -0,0 +1,99 @@
+package config
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLoadExpandsEnv(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	t.Setenv("NODE_PW", "pa:ss #word")
+	t.Setenv("SSH_PORT", "2222")
+	path := writeConfig(t, `
+cluster:
+  token: test-token
+servers:
+  - ip: 192.0.2.10
+    password: ${NODE_PW}
+    port: ${SSH_PORT}
+agents:
+  - ip: 192.0.2.11
+    password: $${NODE_PW}
+`)
+	cfg, err := LoadWithOptions(path, LoadOptions{StrictEnv: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := cfg.Servers[0]
+	if srv.Password != "pa:ss #word" {
+		t.Errorf("password = %q, want the NODE_PW value taken literally", srv.Password)
+	}
+	if srv.Port != 2222 {
+		t.Errorf("port = %d, want 2222", srv.Port)
+	}
+	if pw := cfg.Agents[0].Password; pw != "${NODE_PW}" {
+		t.Errorf("agent password = %q, want $$ to yield a literal $", pw)
+	}
+}
+
+func TestLoadStrictEnv(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	content := `
+cluster:
+  token: ${K3AIR_TEST_UNSET_TOKEN}
+servers:
+  - ip: 192.0.2.10
+    password: ${K3AIR_TEST_UNSET_PW}
+`
+	_, err := LoadWithOptions(writeConfig(t, content), LoadOptions{StrictEnv: true})
+	want := "config references unset environment variable(s): K3AIR_TEST_UNSET_PW, K3AIR_TEST_UNSET_TOKEN"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("got error %v, want %q", err, want)
+	}
+
+	// Without StrictEnv unset variables expand to nothing, with a warning
+	var log bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&log, nil)))
+	_, err = LoadWithOptions(writeConfig(t, content), LoadOptions{})
+	if err == nil || strings.Contains(err.Error(), "unset environment variable") {
+		t.Fatalf("got error %v, want the empty password to fail validation instead", err)
+	}
+	for _, name := range []string{"K3AIR_TEST_UNSET_PW", "K3AIR_TEST_UNSET_TOKEN"} {
+		if !strings.Contains(log.String(), "unset environment variable, using an empty value\" variable="+name) {
+			t.Errorf("no warning naming %s in log:\n%s", name, log.String())
+		}
+	}
+}
+
+func TestLoadKeepsHookCommands(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	t.Setenv("HOOKS_DIR", "/srv/hooks")
+	path := writeConfig(t, `
+cluster:
+  token: test-token
+servers:
+  - ip: 192.0.2.10
+    password: secret
+    pre-install-hooks:
+      - echo $HOSTNAME ${1} $?
+      - command: test -d $$HOME
+      - script: ${HOOKS_DIR}/prepare.sh
+`)
+	cfg, err := LoadWithOptions(path, LoadOptions{StrictEnv: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks := cfg.Servers[0].PreInstallHooks
+	if got := hooks[0].Command; got != "echo $HOSTNAME ${1} $?" {
+		t.Errorf("command = %q, want it passed to the node shell unexpanded", got)
+	}
+	if got := hooks[1].Command; got != "test -d $$HOME" {
+		t.Errorf("command = %q, want $$ left alone", got)
+	}
+	if got := hooks[2].Script; got != "/srv/hooks/prepare.sh" {
+		t.Errorf("script = %q, want the script path expanded", got)
+	}
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultK3sBinary is the k3s binary used when none is configured
+const defaultK3sBinary = "k3s"
+
+// defaultAirgapTarball is the airgap images archive used when none is
+// configured. It is optional, so a missing default is not an error.
+const defaultAirgapTarball = "k3s-airgap-images-amd64.tar.gz"
+
+// ReleaseK3sBinary reports whether the k3s binary comes from the release of
+// k3s-version: the version is set and the binary is left at its default
+func (c *Config) ReleaseK3sBinary() bool {
+	return c.Cluster.K3sVersion != "" && filepath.Clean(c.Assets.K3sBinary) == c.AssetPath(defaultK3sBinary) && len(c.Assets.K3sBinaryURLs) == 0
+}
+
+// ReleaseAirgapTarball is ReleaseK3sBinary for the airgap images archive
+func (c *Config) ReleaseAirgapTarball() bool {
+	return c.Cluster.K3sVersion != "" && filepath.Clean(c.Assets.K3sAirgapTarball) == c.AssetPath(defaultAirgapTarball) && len(c.Assets.K3sAirgapTarballURLs) == 0
+}
+
+// PreflightFiles checks that every local file the config references exists:
+// node key_path files and hook scripts, the k3s binary and airgap images (unless they are
+// URLs), the binary signature and signing key, the datastore TLS files and
+// registry CA certificates. All missing files are reported at once so
+// typos surface before any node is touched.
+func (c *Config) PreflightFiles() error {
+	var missing []string
+	check := func(what, path string) {
+		if path == "" || isURLPath(path) {
+			return
+		}
+		if _, err := os.Stat(path); err != nil {
+			missing = append(missing, fmt.Sprintf("%s: %v", what, err))
+		}
+	}
+
+	checkNode := func(where string, node Node) {
+		check("key_path of "+where, node.KeyPath)
+		for _, h := range append(append([]Hook{}, node.PreInstallHooks...), node.PostInstallHooks...) {
+			check("hook script of "+where, h.Script)
+		}
+	}
+	for idx, node := range c.Servers {
+		checkNode(fmt.Sprintf("servers[%d] (%s)", idx, node.IP), node)
+	}
+	for idx, node := range c.Agents {
+		checkNode(fmt.Sprintf("agents[%d] (%s)", idx, node.IP), node)
+	}
+
+	// A missing local asset is fine when a mirror or the k3s-version
+	// release can provide it
+	if len(c.Assets.K3sBinaryURLs) == 0 && !c.ReleaseK3sBinary() {
+		check("assets.k3s-binary", c.Assets.K3sBinary)
+	}
+	if len(c.Assets.K3sAirgapTarballURLs) == 0 && !c.ReleaseAirgapTarball() {
+		if tarball := c.AssetPath(defaultAirgapTarball); filepath.Clean(c.Assets.K3sAirgapTarball) == tarball {
+			if _, err := os.Stat(tarball); err != nil {
+				slog.Warn("airgap images archive not found, nodes will pull images from registries", "file", tarball)
+			}
+		} else {
+			check("assets.k3s-airgap-tarball", c.Assets.K3sAirgapTarball)
+		}
+	}
+
+	check("assets.k3s-binary-sig", c.Assets.K3sBinarySig)
+	check("assets.signing-key", c.Assets.SigningKey)
+	check("assets.ca-file", c.Assets.CAFile)
+	check("cluster.datastore-cafile", c.Cluster.DatastoreCAFile)
+	check("cluster.datastore-certfile", c.Cluster.DatastoreCertFile)
+	check("cluster.datastore-keyfile", c.Cluster.DatastoreKeyFile)
+	if c.Cluster.MetalLB != nil {
+		check("cluster.metallb.manifest", c.Cluster.MetalLB.Manifest)
+	}
+	if c.Cluster.IngressNginx != nil {
+		check("cluster.ingress-nginx.manifest", c.Cluster.IngressNginx.Manifest)
+	}
+	for _, source := range c.Cluster.ExtraImages {
+		check("cluster.extra-images", source)
+	}
+	for _, name := range c.Cluster.RegistryNames() {
+		if tls := c.Cluster.RegistryMirrors[name].TLS; tls != nil {
+			check(fmt.Sprintf("tls.ca-file of registry-mirrors %s", name), tls.CAFile)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%d referenced file(s) cannot be read:\n  - %s", len(missing), strings.Join(missing, "\n  - "))
+	}
+	return nil
+}
+
+// isURLPath reports whether an asset source is downloaded rather than read locally
+func isURLPath(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Hook is a step run on a node around its install: a shell command, or a
+// local script that is uploaded and executed. It is written in the config
+// either as the command string or as a mapping.
+type Hook struct {
+	Command string `yaml:"command"`
+	Script  string `yaml:"script"`
+	// IgnoreErrors logs a failing hook instead of aborting the node
+	IgnoreErrors bool `yaml:"ignore_errors"`
+}
+
+// UnmarshalYAML accepts a command string or a hook mapping
+func (h *Hook) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		return n.Decode(&h.Command)
+	}
+	type plain Hook
+	return n.Decode((*plain)(h))
+}
+
+// validateHooks checks that every hook has either a command or a script
+func validateHooks(node Node) error {
+	for _, stage := range []struct {
+		name  string
+		hooks []Hook
+	}{
+		{"pre-install-hooks", node.PreInstallHooks},
+		{"post-install-hooks", node.PostInstallHooks},
+	} {
+		for idx, h := range stage.hooks {
+			if (h.Command == "") == (h.Script == "") {
+				return fmt.Errorf("%s[%d]: set either command or script", stage.name, idx)
+			}
+		}
+	}
+	return nil
+}
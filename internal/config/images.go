@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// imageArchiveExts are the archive types k3s imports from its images
+// directory
+var imageArchiveExts = []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz", ".tar.zst", ".tzst", ".tar.lz4"}
+
+// ImageArchiveName returns the file name an extra images archive is
+// uploaded as, the base name of its path or URL
+func ImageArchiveName(source string) string {
+	if isURLPath(source) {
+		if u, err := url.Parse(source); err == nil {
+			return path.Base(u.Path)
+		}
+	}
+	return filepath.Base(source)
+}
+
+// validateExtraImages checks that every extra images archive has a type k3s
+// imports and a file name of its own in the images directory
+func validateExtraImages(c Cluster) error {
+	seen := map[string]string{defaultAirgapTarball: "the airgap images archive"}
+	for _, source := range c.ExtraImages {
+		name := ImageArchiveName(source)
+		known := false
+		for _, ext := range imageArchiveExts {
+			known = known || strings.HasSuffix(name, ext)
+		}
+		if !known {
+			return fmt.Errorf("extra-images: %s is not an image archive k3s imports: must end in %s", source, strings.Join(imageArchiveExts, ", "))
+		}
+		if other, ok := seen[name]; ok {
+			return fmt.Errorf("extra-images: %s has the same file name as %s", source, other)
+		}
+		seen[name] = source
+	}
+	return nil
+}
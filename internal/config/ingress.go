@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Ingress controllers k3air can set up
+const (
+	IngressControllerNone    = "none"
+	IngressControllerTraefik = "traefik"
+	IngressControllerNginx   = "nginx"
+)
+
+// DefaultIngressNginxManifest is the ingress-nginx release deployed when
+// ingress-nginx.manifest is not set
+const DefaultIngressNginxManifest = "https://raw.githubusercontent.com/kubernetes/ingress-nginx/controller-v1.11.3/deploy/static/provider/baremetal/deploy.yaml"
+
+// IngressNginx configures the ingress-nginx controller
+type IngressNginx struct {
+	// Manifest is the URL or local path of the ingress-nginx manifest for
+	// bare metal; air-gapped installs need a local copy and the images in the
+	// airgap images tarball
+	Manifest string `yaml:"manifest"`
+	// HostNetwork runs the controller as a DaemonSet on the host network, so
+	// every node serves ports 80 and 443 without a load balancer
+	HostNetwork bool `yaml:"host-network"`
+}
+
+// ManifestSource returns the configured manifest, or the default release
+func (n *IngressNginx) ManifestSource() string {
+	if n == nil || n.Manifest == "" {
+		return DefaultIngressNginxManifest
+	}
+	return n.Manifest
+}
+
+// IngressController returns the ingress controller of the cluster: the
+// configured one, or the bundled traefik unless it is disabled
+func (c Cluster) IngressController() string {
+	if c.Ingress != "" {
+		return c.Ingress
+	}
+	if slices.Contains(c.Disable, "traefik") {
+		return IngressControllerNone
+	}
+	return IngressControllerTraefik
+}
+
+// Disabled returns the packaged components k3s is started without: the
+// configured ones and those replaced by MetalLB or another ingress controller
+func (c Cluster) Disabled() []string {
+	var disabled []string
+	add := func(d string) {
+		if d != "" && !slices.Contains(disabled, d) {
+			disabled = append(disabled, d)
+		}
+	}
+	for _, d := range c.Disable {
+		add(d)
+	}
+	// MetalLB and servicelb would both answer for LoadBalancer services
+	if c.MetalLB != nil {
+		add("servicelb")
+	}
+	if c.IngressController() != IngressControllerTraefik {
+		add("traefik")
+	}
+	return disabled
+}
+
+// validateIngress checks the ingress controller against the disabled
+// components
+func validateIngress(c Cluster) error {
+	switch c.Ingress {
+	case "", IngressControllerNone, IngressControllerNginx:
+	case IngressControllerTraefik:
+		if slices.Contains(c.Disable, "traefik") {
+			return fmt.Errorf("ingress is traefik but traefik is disabled: remove it from disable (or disable-traefik)")
+		}
+	default:
+		return fmt.Errorf("invalid ingress %q: must be %s, %s or %s", c.Ingress, IngressControllerNone, IngressControllerTraefik, IngressControllerNginx)
+	}
+	if c.IngressNginx != nil && c.Ingress != IngressControllerNginx {
+		return fmt.Errorf("ingress-nginx is configured but ingress is not nginx")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+
+	"gopkg.in/yaml.v3"
+)
+
+// K3sConfig is the content of k3s' own config file,
+// /etc/rancher/k3s/config.yaml. It is written in the k3air config either as
+// a raw YAML string or as a mapping, and holds YAML text either way.
+type K3sConfig string
+
+// UnmarshalYAML accepts a string or a mapping, which is rendered back to YAML
+func (k *K3sConfig) UnmarshalYAML(n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		var s string
+		if err := n.Decode(&s); err != nil {
+			return err
+		}
+		*k = K3sConfig(s)
+	case yaml.MappingNode:
+		out, err := yaml.Marshal(n)
+		if err != nil {
+			return err
+		}
+		*k = K3sConfig(out)
+	default:
+		return fmt.Errorf("line %d: config-yaml must be a string or a mapping", n.Line)
+	}
+	return nil
+}
+
+// k3sManagedKeys are always passed as flags by k3air, which take precedence
+// over the config file, so setting them in config-yaml has no effect
+var k3sManagedKeys = []string{"token", "server", "cluster-init"}
+
+// parse returns the top-level keys of the config, which must be a mapping
+func (k K3sConfig) parse() (map[string]any, error) {
+	m := map[string]any{}
+	if err := yaml.Unmarshal([]byte(k), &m); err != nil {
+		return nil, fmt.Errorf("config-yaml must be a valid YAML mapping: %w", err)
+	}
+	return m, nil
+}
+
+// validateK3sConfig checks that config-yaml is a YAML mapping, and warns
+// about keys k3air overrides
+func validateK3sConfig(where string, k K3sConfig) error {
+	if k == "" {
+		return nil
+	}
+	m, err := k.parse()
+	if err != nil {
+		return err
+	}
+	for _, key := range k3sManagedKeys {
+		if _, ok := m[key]; ok {
+			slog.Warn("config-yaml sets a value that k3air passes as a flag, the flag wins", "in", where, "key", key)
+		}
+	}
+	return nil
+}
+
+// K3sConfig returns the content of /etc/rancher/k3s/config.yaml for node:
+// the cluster config-yaml, with the top-level keys of the node's own
+// config-yaml replacing the cluster's. It returns nil when neither is set.
+func (c *Config) K3sConfig(node Node) ([]byte, error) {
+	switch {
+	case node.ConfigYAML == "" && c.Cluster.ConfigYAML == "":
+		return nil, nil
+	case node.ConfigYAML == "":
+		return []byte(c.Cluster.ConfigYAML), nil
+	case c.Cluster.ConfigYAML == "":
+		return []byte(node.ConfigYAML), nil
+	}
+	merged, err := c.Cluster.ConfigYAML.parse()
+	if err != nil {
+		return nil, err
+	}
+	override, err := node.ConfigYAML.parse()
+	if err != nil {
+		return nil, err
+	}
+	for key, v := range override {
+		merged[key] = v
+	}
+	return yaml.Marshal(merged)
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// quantityPattern matches a Kubernetes resource quantity such as 500m or 1Gi
+var quantityPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(m|k|M|G|T|P|E|Ki|Mi|Gi|Ti|Pi|Ei)?$`)
+
+// reservableResources are the resources kubelet can reserve for the system
+var reservableResources = []string{"cpu", "memory", "ephemeral-storage", "pid"}
+
+// managedKubeletArgs are kubelet flags k3s derives from settings k3air
+// manages, which a kubelet-args entry would silently fight with
+var managedKubeletArgs = map[string]string{
+	"cluster-dns":          "cluster-dns",
+	"cluster-domain":       "cluster-domain",
+	"hostname-override":    "node_name",
+	"node-ip":              "node_ip",
+	"node-labels":          "labels",
+	"register-with-taints": "taints",
+}
+
+// validateKubelet checks the reserved resources and kubelet-args of node
+func validateKubelet(node Node) error {
+	if err := validateReserved("system-reserved", node.SystemReserved); err != nil {
+		return err
+	}
+	if err := validateReserved("kube-reserved", node.KubeReserved); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, a := range node.KubeletArgs {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		if strings.HasPrefix(a, "-") {
+			return fmt.Errorf("invalid kubelet-args entry %q: give the flag without leading dashes, e.g. max-pods=110", a)
+		}
+		name, _, _ := strings.Cut(a, "=")
+		if setting, ok := managedKubeletArgs[name]; ok {
+			return fmt.Errorf("invalid kubelet-args entry %q: %s is managed by k3air, use %s instead", a, name, setting)
+		}
+		if (name == "system-reserved" && node.SystemReserved != "") || (name == "kube-reserved" && node.KubeReserved != "") {
+			return fmt.Errorf("invalid kubelet-args entry %q: %s is already set on the node", a, name)
+		}
+		if seen[name] {
+			return fmt.Errorf("invalid kubelet-args entry %q: %s is given more than once", a, name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// validateReserved checks that value is a comma separated list of
+// resource=quantity pairs, such as cpu=500m,memory=1Gi
+func validateReserved(field, value string) error {
+	if value == "" {
+		return nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		name, quantity, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid %s %q: expected resource=quantity pairs such as cpu=500m,memory=1Gi", field, value)
+		}
+		if !slices.Contains(reservableResources, name) {
+			return fmt.Errorf("invalid %s %q: unknown resource %q, must be one of %s", field, value, name, strings.Join(reservableResources, ", "))
+		}
+		if !quantityPattern.MatchString(quantity) {
+			return fmt.Errorf("invalid %s %q: %q is not a resource quantity", field, value, quantity)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// isManifestFile reports whether k3s auto-applies a file with this name
+func isManifestFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// ManifestFiles expands the configured manifest paths into the list of files
+// to upload. Directories contribute their .yaml/.yml files (not recursively),
+// in name order; other files in them are skipped with a warning.
+func ManifestFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s: %w", p, err)
+		}
+		if !info.IsDir() {
+			if !isManifestFile(p) {
+				slog.Warn("manifest does not end in .yaml or .yml, k3s will not apply it", "file", p)
+			}
+			files = append(files, p)
+			continue
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, fmt.Errorf("manifest directory %s: %w", p, err)
+		}
+		var names []string
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			if !isManifestFile(e.Name()) {
+				slog.Warn("skipping non-yaml file in manifest directory", "file", filepath.Join(p, e.Name()))
+				continue
+			}
+			names = append(names, e.Name())
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			files = append(files, filepath.Join(p, n))
+		}
+	}
+	return files, nil
+}
+
+// validateManifests checks that every manifest parses as YAML and that no two
+// manifests would overwrite each other in the manifests directory
+func validateManifests(paths []string) error {
+	files, err := ManifestFiles(paths)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]string)
+	for _, f := range files {
+		base := filepath.Base(f)
+		if prev, ok := seen[base]; ok {
+			return fmt.Errorf("manifests %s and %s have the same file name %s", prev, f, base)
+		}
+		seen[base] = f
+
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("manifest %s: %w", f, err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		for {
+			var doc yaml.Node
+			if err := dec.Decode(&doc); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return fmt.Errorf("manifest %s is not valid YAML: %w", f, err)
+			}
+		}
+	}
+	return nil
+}
+
+// IsLocalChart reports whether chart refers to a local chart archive
+func IsLocalChart(chart string) bool {
+	return strings.HasSuffix(chart, ".tgz") && !strings.Contains(chart, "://")
+}
+
+// validateHelmCharts checks names, chart archives and values files
+func validateHelmCharts(charts []HelmChart) error {
+	seen := make(map[string]bool)
+	for _, hc := range charts {
+		if hc.Name == "" {
+			return fmt.Errorf("helm-charts: every entry needs a name")
+		}
+		if seen[hc.Name] {
+			return fmt.Errorf("helm-charts: duplicate name %s", hc.Name)
+		}
+		seen[hc.Name] = true
+
+		switch {
+		case hc.Chart == "":
+			if hc.Repo != "" || hc.Version != "" {
+				return fmt.Errorf("helm chart %s: repo and version require chart", hc.Name)
+			}
+			if hc.ValuesFile == "" {
+				return fmt.Errorf("helm chart %s: set chart to install a chart, or values-file to configure a bundled one", hc.Name)
+			}
+		case IsLocalChart(hc.Chart):
+			if hc.Repo != "" {
+				return fmt.Errorf("helm chart %s: repo cannot be used with a local chart archive", hc.Name)
+			}
+			if _, err := os.Stat(hc.Chart); err != nil {
+				return fmt.Errorf("helm chart %s: chart archive: %w", hc.Name, err)
+			}
+		}
+
+		if hc.ValuesFile != "" {
+			b, err := os.ReadFile(hc.ValuesFile)
+			if err != nil {
+				return fmt.Errorf("helm chart %s: values file: %w", hc.Name, err)
+			}
+			var values map[string]interface{}
+			if err := yaml.Unmarshal(b, &values); err != nil {
+				return fmt.Errorf("helm chart %s: values file %s is not a valid YAML mapping: %w", hc.Name, hc.ValuesFile, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// nodeListKeys are the top-level lists that files merged together append to
+// instead of replacing
+var nodeListKeys = []string{"servers", "agents", "nodes"}
+
+// DirFiles returns the *.yaml and *.yml files in dir, sorted by name, the
+// order they are merged in
+func DirFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.Type().IsRegular() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .yaml or .yml files in %s", dir)
+	}
+	return files, nil
+}
+
+// readDocs reads and merges the YAML documents at paths into one. Each file
+// is checked for unknown fields and has its environment variables expanded
+// and its local files resolved against its own directory before merging, so
+// errors name the file and a file's paths do not depend on the first file.
+func readDocs(paths []string, opts LoadOptions) (*yaml.Node, error) {
+	var merged *yaml.Node
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal(b, &doc); err != nil {
+			return nil, fileError(paths, path, err)
+		}
+		if err := checkKnownFields(b, &Config{}); err != nil {
+			return nil, fileError(paths, path, err)
+		}
+		if err := expandEnv(&doc, opts.StrictEnv); err != nil {
+			return nil, fileError(paths, path, err)
+		}
+		if err := resolveDocPaths(&doc, filepath.Dir(path), opts.AssetsDir == ""); err != nil {
+			return nil, fileError(paths, path, err)
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		if merged == nil {
+			merged = &doc
+			continue
+		}
+		if err := mergeYAML(merged.Content[0], doc.Content[0], ""); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if merged == nil {
+		merged = &yaml.Node{Kind: yaml.DocumentNode}
+	}
+	return merged, nil
+}
+
+// fileError prefixes err with the file it comes from when several files are
+// loaded
+func fileError(paths []string, path string, err error) error {
+	if len(paths) == 1 {
+		return err
+	}
+	return fmt.Errorf("%s: %w", path, err)
+}
+
+// mergeYAML merges src into dst. Mappings are merged key by key, the
+// servers, agents and nodes lists are appended to, and anything else in src
+// replaces the value in dst. key is the dotted path of dst, for errors.
+func mergeYAML(dst, src *yaml.Node, key string) error {
+	if dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		if key == "" {
+			return fmt.Errorf("the top level must be a mapping")
+		}
+		*dst = *src
+		return nil
+	}
+	for idx := 0; idx+1 < len(src.Content); idx += 2 {
+		k, v := src.Content[idx], src.Content[idx+1]
+		path := k.Value
+		if key != "" {
+			path = key + "." + k.Value
+		}
+		existing := mappingValue(dst, k.Value)
+		switch {
+		case existing == nil:
+			dst.Content = append(dst.Content, k, v)
+		case key == "" && slices.Contains(nodeListKeys, k.Value) &&
+			existing.Kind == yaml.SequenceNode && v.Kind == yaml.SequenceNode:
+			existing.Content = append(existing.Content, v.Content...)
+		default:
+			if err := mergeYAML(existing, v, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the value of key in the mapping n, or nil
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	for idx := 0; idx+1 < len(n.Content); idx += 2 {
+		if n.Content[idx].Value == key {
+			return n.Content[idx+1]
+		}
+	}
+	return nil
+}
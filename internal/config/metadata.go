@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// kubeletLabels are the labels in the kubernetes.io and k8s.io namespaces
+// that the kubelet may set on its own node at registration
+var kubeletLabels = []string{
+	"kubernetes.io/hostname",
+	"kubernetes.io/instance-type",
+	"kubernetes.io/os",
+	"kubernetes.io/arch",
+	"beta.kubernetes.io/instance-type",
+	"beta.kubernetes.io/os",
+	"beta.kubernetes.io/arch",
+	"failure-domain.beta.kubernetes.io/zone",
+	"failure-domain.beta.kubernetes.io/region",
+	"topology.kubernetes.io/zone",
+	"topology.kubernetes.io/region",
+}
+
+// kubeletLabelNamespaces are the namespaces, and their subdomains, the
+// kubelet may set any label of
+var kubeletLabelNamespaces = []string{"kubelet.kubernetes.io", "node.kubernetes.io"}
+
+// RegistrationLabel reports whether the kubelet accepts label, key=value,
+// through --node-label. Other labels in the kubernetes.io and k8s.io
+// namespaces, such as node-role.kubernetes.io/worker, are rejected at
+// registration and can only be set once the node has joined.
+func RegistrationLabel(label string) bool {
+	key, _, _ := strings.Cut(label, "=")
+	ns, _, ok := strings.Cut(key, "/")
+	if !ok || slices.Contains(kubeletLabels, key) {
+		return true
+	}
+	for _, allowed := range kubeletLabelNamespaces {
+		if ns == allowed || strings.HasSuffix(ns, "."+allowed) {
+			return true
+		}
+	}
+	for _, restricted := range []string{"kubernetes.io", "k8s.io"} {
+		if ns == restricted || strings.HasSuffix(ns, "."+restricted) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateMetadata checks that every label and annotation has the form
+// key=value
+func validateMetadata(node Node) error {
+	for _, l := range node.Labels {
+		if key, _, ok := strings.Cut(l, "="); !ok || key == "" {
+			return fmt.Errorf("invalid label %q: expected key=value", l)
+		}
+	}
+	for _, a := range node.Annotations {
+		if key, _, ok := strings.Cut(a, "="); !ok || key == "" {
+			return fmt.Errorf("invalid annotation %q: expected key=value", a)
+		}
+	}
+	return nil
+}
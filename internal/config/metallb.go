@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// DefaultMetalLBManifest is the MetalLB release deployed when metallb.manifest
+// is not set
+const DefaultMetalLBManifest = "https://raw.githubusercontent.com/metallb/metallb/v0.14.9/config/manifests/metallb-native.yaml"
+
+// MetalLB deploys MetalLB in L2 mode, which gives LoadBalancer services an
+// address from Addresses on bare metal
+type MetalLB struct {
+	// Manifest is the URL or local path of the MetalLB manifest, such as
+	// metallb-native.yaml; air-gapped installs need a local copy and the
+	// MetalLB images in the airgap images tarball
+	Manifest string `yaml:"manifest"`
+	// Addresses are the CIDRs or first-last ranges handed out to services
+	Addresses []string `yaml:"addresses"`
+}
+
+// ManifestSource returns the configured manifest, or the default release
+func (m *MetalLB) ManifestSource() string {
+	if m.Manifest == "" {
+		return DefaultMetalLBManifest
+	}
+	return m.Manifest
+}
+
+// addressRange is an inclusive range of IP addresses of one family
+type addressRange struct {
+	first, last netip.Addr
+}
+
+func (r addressRange) overlaps(o addressRange) bool {
+	return r.first.Is4() == o.first.Is4() && r.first.Compare(o.last) <= 0 && o.first.Compare(r.last) <= 0
+}
+
+// parseAddressRange parses a CIDR or a first-last range
+func parseAddressRange(s string) (addressRange, error) {
+	if first, last, ok := strings.Cut(s, "-"); ok {
+		a, err := netip.ParseAddr(strings.TrimSpace(first))
+		if err != nil {
+			return addressRange{}, err
+		}
+		b, err := netip.ParseAddr(strings.TrimSpace(last))
+		if err != nil {
+			return addressRange{}, err
+		}
+		if a.Is4() != b.Is4() || a.Compare(b) > 0 {
+			return addressRange{}, fmt.Errorf("%s is not an ascending range of one address family", s)
+		}
+		return addressRange{a, b}, nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return addressRange{}, err
+	}
+	return prefixRange(p.Masked()), nil
+}
+
+// prefixRange returns the addresses of p, which must be masked
+func prefixRange(p netip.Prefix) addressRange {
+	b := p.Addr().AsSlice()
+	for bit := p.Bits(); bit < len(b)*8; bit++ {
+		b[bit/8] |= 0x80 >> (bit % 8)
+	}
+	last, _ := netip.AddrFromSlice(b)
+	return addressRange{p.Addr(), last}
+}
+
+// netRange converts a net.IPNet into an address range
+func netRange(n *net.IPNet) addressRange {
+	p, _ := netip.ParsePrefix(n.String())
+	return prefixRange(p.Masked())
+}
+
+// validateMetalLB checks that the MetalLB address pool is not empty and does
+// not overlap the pod and service networks, the nodes or the API VIP
+func (c *Config) validateMetalLB(clusterCIDRs, serviceCIDRs []*net.IPNet) error {
+	m := c.Cluster.MetalLB
+	if m == nil {
+		return nil
+	}
+	if len(m.Addresses) == 0 {
+		return fmt.Errorf("metallb: addresses must list at least one CIDR or first-last range")
+	}
+	var nodeIPs []string
+	for _, node := range append(append([]Node{}, c.Servers...), c.Agents...) {
+		nodeIPs = append(nodeIPs, node.IP)
+		for _, s := range strings.Split(node.NodeIP+","+node.NodeExternalIP, ",") {
+			nodeIPs = append(nodeIPs, strings.TrimSpace(s))
+		}
+	}
+	if c.Cluster.APIVIP != "" {
+		nodeIPs = append(nodeIPs, c.Cluster.APIVIP)
+	}
+	for _, s := range m.Addresses {
+		r, err := parseAddressRange(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("metallb: invalid address pool %q: %w", s, err)
+		}
+		for _, n := range clusterCIDRs {
+			if r.overlaps(netRange(n)) {
+				return fmt.Errorf("metallb: address pool %s overlaps cluster-cidr %s", s, n)
+			}
+		}
+		for _, n := range serviceCIDRs {
+			if r.overlaps(netRange(n)) {
+				return fmt.Errorf("metallb: address pool %s overlaps service-cidr %s", s, n)
+			}
+		}
+		for _, ip := range nodeIPs {
+			if a, err := netip.ParseAddr(ip); err == nil && r.overlaps(addressRange{a, a}) {
+				return fmt.Errorf("metallb: address pool %s contains %s, which is a node address or the api-vip", s, ip)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package config
+
+import "fmt"
+
+// Node roles
+const (
+	RoleServer = "server"
+	RoleAgent  = "agent"
+)
+
+// assignRoles sorts the nodes list into Servers and Agents, and checks the
+// role and primary markers of every node. The primary server is moved to
+// the front of Servers, where the installer expects it; without a marker
+// the first server stays the primary.
+func (c *Config) assignRoles() error {
+	nodesList := len(c.Nodes) > 0
+	if nodesList {
+		if len(c.Servers) > 0 || len(c.Agents) > 0 {
+			return fmt.Errorf("nodes cannot be combined with servers or agents: list every node under nodes")
+		}
+		for i, n := range c.Nodes {
+			switch n.Role {
+			case RoleServer:
+				c.Servers = append(c.Servers, n)
+			case RoleAgent:
+				c.Agents = append(c.Agents, n)
+			case "":
+				return fmt.Errorf("nodes[%d] (%s): role is required: must be %s or %s", i, n.IP, RoleServer, RoleAgent)
+			default:
+				return fmt.Errorf("nodes[%d] (%s): invalid role %q: must be %s or %s", i, n.IP, n.Role, RoleServer, RoleAgent)
+			}
+		}
+		c.Nodes = nil
+	}
+
+	primary := -1
+	for i := range c.Servers {
+		n := &c.Servers[i]
+		if n.Role != "" && n.Role != RoleServer {
+			return fmt.Errorf("servers[%d] (%s): role is %q but the node is listed under servers", i, n.IP, n.Role)
+		}
+		n.Role = RoleServer
+		if !n.Primary {
+			continue
+		}
+		if primary >= 0 {
+			return fmt.Errorf("servers[%d] (%s): more than one primary server, %s is already primary", i, n.IP, c.Servers[primary].IP)
+		}
+		primary = i
+	}
+	for i := range c.Agents {
+		n := &c.Agents[i]
+		if n.Role != "" && n.Role != RoleAgent {
+			return fmt.Errorf("agents[%d] (%s): role is %q but the node is listed under agents", i, n.IP, n.Role)
+		}
+		if n.Primary {
+			return fmt.Errorf("agents[%d] (%s): only a server can be primary", i, n.IP)
+		}
+		n.Role = RoleAgent
+	}
+	if primary < 0 {
+		if len(c.Servers) > 1 && nodesList {
+			return fmt.Errorf("no primary server: mark exactly one server with primary: true")
+		}
+		if len(c.Servers) > 0 {
+			c.Servers[0].Primary = true
+		}
+		return nil
+	}
+	// Keep the order of the other servers
+	p := c.Servers[primary]
+	copy(c.Servers[1:primary+1], c.Servers[:primary])
+	c.Servers[0] = p
+	return nil
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AssetPath returns where the local asset name is: relative paths are
+// relative to the assets directory
+func (c *Config) AssetPath(name string) string {
+	if c.assetsDir == "" || c.assetsDir == "." || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(c.assetsDir, name)
+}
+
+// FileURLPath returns the path of a file:// URL, and any other source
+// unchanged
+func FileURLPath(source string) (string, error) {
+	if !strings.HasPrefix(source, "file://") {
+		return source, nil
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return "", fmt.Errorf("invalid file URL %q: %w", source, err)
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("invalid file URL %q: only local files are supported, use file:///path", source)
+	}
+	return u.Path, nil
+}
+
+// resolvePath turns a file:// URL into a path and makes a relative local
+// path relative to dir. http(s) URLs, absolute paths and paths starting
+// with ~/ are returned unchanged.
+func resolvePath(dir, p string) (string, error) {
+	p, err := FileURLPath(p)
+	if err != nil {
+		return "", err
+	}
+	if p == "" || isURLPath(p) || filepath.IsAbs(p) || strings.HasPrefix(p, "~/") || dir == "" || dir == "." {
+		return p, nil
+	}
+	return filepath.Join(dir, p), nil
+}
+
+// docPaths are the keys of the local files a config file references, as
+// paths into its YAML document: [] stands for every item of a list and * for
+// every value of a mapping. They are the files resolvePaths resolves, found
+// before the files are merged so each is resolved against its own file, and
+// the config works from any working directory. The kubeconfig-path output
+// stays relative to the working directory.
+var docPaths = [][]string{
+	{"cluster", "token-file"},
+	{"cluster", "datastore-cafile"},
+	{"cluster", "datastore-certfile"},
+	{"cluster", "datastore-keyfile"},
+	{"cluster", "ssh-config"},
+	{"cluster", "known-hosts"},
+	{"cluster", "manifests", "[]"},
+	{"cluster", "extra-images", "[]"},
+	{"cluster", "helm-charts", "[]", "chart"},
+	{"cluster", "helm-charts", "[]", "values-file"},
+	{"cluster", "metallb", "manifest"},
+	{"cluster", "ingress-nginx", "manifest"},
+	{"cluster", "storage", "longhorn", "chart"},
+	{"cluster", "storage", "longhorn", "values-file"},
+	{"cluster", "registry-mirrors", "*", "auth", "password-file"},
+	{"cluster", "registry-mirrors", "*", "auth", "token-file"},
+	{"cluster", "registry-mirrors", "*", "tls", "ca-file"},
+}
+
+// docAssetPaths are the keys of the files of the assets section, resolved
+// like docPaths unless an assets directory is given
+var docAssetPaths = [][]string{
+	{"assets", "k3s-binary"},
+	{"assets", "k3s-airgap-tarball"},
+	{"assets", "k3s-binary-urls", "[]"},
+	{"assets", "k3s-airgap-tarball-urls", "[]"},
+	{"assets", "k3s-binary-sig"},
+	{"assets", "signing-key"},
+	{"assets", "ca-file"},
+}
+
+// docNodePaths are the keys of the local files of a node, under each of
+// nodeListKeys
+var docNodePaths = [][]string{
+	{"key_path"},
+	{"password_file"},
+	{"pre-install-hooks", "[]", "script"},
+	{"post-install-hooks", "[]", "script"},
+}
+
+// resolveDocPaths resolves the local files doc, the document of a config
+// file in dir, references against dir. Paths in the assets section are left
+// for resolvePaths when withAssets is false.
+func resolveDocPaths(doc *yaml.Node, dir string, withAssets bool) error {
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	keys := docPaths
+	if withAssets {
+		keys = append(slices.Clip(keys), docAssetPaths...)
+	}
+	for _, list := range nodeListKeys {
+		for _, k := range docNodePaths {
+			keys = append(slices.Clip(keys), append([]string{list, "[]"}, k...))
+		}
+	}
+	for _, k := range keys {
+		if err := resolveDocPath(doc.Content[0], k, dir); err != nil {
+			return fmt.Errorf("%s: %w", strings.Join(k, "."), err)
+		}
+	}
+	return nil
+}
+
+// resolveDocPath resolves the scalars at the key path keys below n against
+// dir. Helm charts are only resolved when they name a local archive.
+func resolveDocPath(n *yaml.Node, keys []string, dir string) error {
+	if len(keys) == 0 {
+		if n.Kind != yaml.ScalarNode {
+			return nil
+		}
+		resolved, err := resolvePath(dir, n.Value)
+		if err != nil {
+			return err
+		}
+		n.Value = resolved
+		return nil
+	}
+	var children []*yaml.Node
+	switch {
+	case keys[0] == "[]" && n.Kind == yaml.SequenceNode:
+		children = n.Content
+	case keys[0] == "*" && n.Kind == yaml.MappingNode:
+		for idx := 1; idx < len(n.Content); idx += 2 {
+			children = append(children, n.Content[idx])
+		}
+	case n.Kind == yaml.MappingNode:
+		v := mappingValue(n, keys[0])
+		if v == nil {
+			return nil
+		}
+		if keys[0] == "chart" && !IsLocalChart(v.Value) {
+			return nil
+		}
+		children = []*yaml.Node{v}
+	}
+	for _, child := range children {
+		if err := resolveDocPath(child, keys[1:], dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveAssetPaths resolves the files of the assets section against
+// assetsDir, which readDocs leaves to it when set, and fills in the default
+// asset files. Without assetsDir, the defaults are relative to dir, the
+// directory of the first config file.
+func (c *Config) resolveAssetPaths(dir, assetsDir string) error {
+	a := &c.Assets
+	c.assetsDir = dir
+	if assetsDir != "" {
+		c.assetsDir = assetsDir
+		assets := []*string{&a.K3sBinary, &a.K3sAirgapTarball, &a.K3sBinarySig, &a.SigningKey, &a.CAFile}
+		for _, list := range [][]string{a.K3sBinaryURLs, a.K3sAirgapTarballURLs} {
+			for idx := range list {
+				assets = append(assets, &list[idx])
+			}
+		}
+		for _, p := range assets {
+			resolved, err := resolvePath(assetsDir, *p)
+			if err != nil {
+				return err
+			}
+			*p = resolved
+		}
+	}
+	if a.K3sBinary == "" {
+		a.K3sBinary = c.AssetPath(defaultK3sBinary)
+	}
+	if a.K3sAirgapTarball == "" {
+		a.K3sAirgapTarball = c.AssetPath(defaultAirgapTarball)
+	}
+	return nil
+}
+
+// resolveNodePaths resolves the local files of node against dir
+func resolveNodePaths(dir string, node *Node) error {
+	paths := []*string{&node.KeyPath, &node.PasswordFile}
+	for _, hooks := range [][]Hook{node.PreInstallHooks, node.PostInstallHooks} {
+		for idx := range hooks {
+			paths = append(paths, &hooks[idx].Script)
+		}
+	}
+	for _, p := range paths {
+		resolved, err := resolvePath(dir, *p)
+		if err != nil {
+			return err
+		}
+		*p = resolved
+	}
+	return nil
+}
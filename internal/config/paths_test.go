@@ -0,0 +1,72 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chdir changes the working directory to dir for the rest of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestLoadResolvesPathsPerFile(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	parent := t.TempDir()
+	for name, content := range map[string]string{
+		"sub/init.yaml": `
+cluster:
+  token: test-token
+assets:
+  k3s-binary: k3s
+servers:
+  - ip: 192.0.2.10
+    key_path: keys/id_ed25519
+`,
+		"sub/k3s": "binary",
+		"nodes/agents.yaml": `
+agents:
+  - ip: 192.0.2.11
+    key_path: id_ed25519
+    pre-install-hooks:
+      - script: hooks/prepare.sh
+`,
+	} {
+		path := filepath.Join(parent, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chdir(t, parent)
+
+	cfg, err := LoadFiles([]string{"sub/init.yaml", "nodes/agents.yaml"}, LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct{ name, got, want string }{
+		{"assets.k3s-binary", cfg.Assets.K3sBinary, filepath.Join("sub", "k3s")},
+		{"default airgap tarball", cfg.AssetPath(defaultAirgapTarball), filepath.Join("sub", defaultAirgapTarball)},
+		{"servers[0].key_path", cfg.Servers[0].KeyPath, filepath.Join("sub", "keys", "id_ed25519")},
+		{"agents[0].key_path", cfg.Agents[0].KeyPath, filepath.Join("nodes", "id_ed25519")},
+		{"agents[0] hook script", cfg.Agents[0].PreInstallHooks[0].Script, filepath.Join("nodes", "hooks", "prepare.sh")},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+	if _, err := os.Stat(cfg.Assets.K3sBinary); err != nil {
+		t.Errorf("k3s binary not found from the parent directory: %v", err)
+	}
+}
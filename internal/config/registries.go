@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RegistryMirror configures how images of one registry are pulled: the
+// endpoints tried in order, and the credentials and TLS settings used to
+// talk to them. It is rendered into k3s' registries.yaml.
+type RegistryMirror struct {
+	Endpoints []string      `yaml:"endpoints"`
+	Auth      *RegistryAuth `yaml:"auth"`
+	TLS       *RegistryTLS  `yaml:"tls"`
+}
+
+// RegistryAuth holds registry credentials: a username and password, or an
+// identity token. The secrets may be read from a file or an environment
+// variable instead of being stored in the config.
+type RegistryAuth struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password-file"`
+	PasswordEnv  string `yaml:"password-env"`
+	Token        string `yaml:"token"`
+	TokenFile    string `yaml:"token-file"`
+	TokenEnv     string `yaml:"token-env"`
+}
+
+// RegistryTLS holds the TLS settings for a registry
+type RegistryTLS struct {
+	// CAFile is a local CA certificate uploaded to every node to verify the
+	// registry
+	CAFile             string `yaml:"ca-file"`
+	InsecureSkipVerify bool   `yaml:"insecure-skip-verify"`
+}
+
+// RegistryNames returns the names of the registry mirrors in sorted order
+func (c Cluster) RegistryNames() []string {
+	names := make([]string, 0, len(c.RegistryMirrors))
+	for name := range c.RegistryMirrors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveRegistrySecrets fills registry passwords and tokens from their
+// file and environment references
+func (c *Cluster) resolveRegistrySecrets() error {
+	for name, m := range c.RegistryMirrors {
+		if m.Auth == nil {
+			continue
+		}
+		a := *m.Auth
+		if err := resolveSecret(&a.Password, a.PasswordFile, a.PasswordEnv, "password"); err != nil {
+			return fmt.Errorf("registry-mirrors %s: %w", name, err)
+		}
+		if err := resolveSecret(&a.Token, a.TokenFile, a.TokenEnv, "token"); err != nil {
+			return fmt.Errorf("registry-mirrors %s: %w", name, err)
+		}
+		m.Auth = &a
+		c.RegistryMirrors[name] = m
+	}
+	return nil
+}
+
+// validateRegistries checks registry-mirrors and that it is not combined
+// with the raw registries content
+func validateRegistries(c Cluster) error {
+	if len(c.RegistryMirrors) == 0 {
+		return nil
+	}
+	if c.Registries != "" {
+		return fmt.Errorf("registries and registry-mirrors cannot both be set: use one of them")
+	}
+	for _, name := range c.RegistryNames() {
+		m := c.RegistryMirrors[name]
+		if name == "" {
+			return fmt.Errorf("registry-mirrors: registry name is empty")
+		}
+		for _, ep := range m.Endpoints {
+			u, err := url.Parse(ep)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("registry-mirrors %s: invalid endpoint %q: must be an http(s) URL", name, ep)
+			}
+		}
+		if a := m.Auth; a != nil {
+			if a.Token != "" && (a.Username != "" || a.Password != "") {
+				return fmt.Errorf("registry-mirrors %s: auth takes a token or a username and password, not both", name)
+			}
+			if a.Token == "" && (a.Username == "" || a.Password == "") {
+				return fmt.Errorf("registry-mirrors %s: auth needs both username and password", name)
+			}
+		}
+	}
+	return nil
+}
+
+// validateSystemDefaultRegistry checks that registry is a host[:port],
+// without scheme or path, as k3s prefixes it to the system image names
+func validateSystemDefaultRegistry(registry string) error {
+	if registry == "" {
+		return nil
+	}
+	if strings.ContainsAny(registry, "/ ") {
+		return fmt.Errorf("invalid system-default-registry %q: must be a host or host:port, without scheme or path", registry)
+	}
+	host := registry
+	if h, port, err := net.SplitHostPort(registry); err == nil {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("invalid system-default-registry %q: bad port %q", registry, port)
+		}
+		host = h
+	}
+	if net.ParseIP(host) == nil && !domainPattern.MatchString(strings.ToLower(host)) {
+		return fmt.Errorf("invalid system-default-registry %q: must be a host or host:port, without scheme or path", registry)
+	}
+	return nil
+}
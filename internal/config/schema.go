@@ -0,0 +1,130 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k3air/internal/sshclient"
+)
+
+// schemaHints add what the struct types cannot tell to the schema of a
+// field, keyed by the Go type name and the YAML key of the field
+var schemaHints = map[string]map[string]any{
+	"Cluster.flannel-backend":        {"enum": flannelBackends, "default": "vxlan"},
+	"Cluster.cluster-cidr":           {"default": "10.42.0.0/16"},
+	"Cluster.service-cidr":           {"default": defaultServiceCidr},
+	"Cluster.cluster-domain":         {"default": "cluster.local"},
+	"Cluster.data-dir":               {"default": "/var/lib/rancher/k3s"},
+	"Cluster.kubeconfig-path":        {"default": "kubeconfig"},
+	"Cluster.host-key-policy":        {"enum": []string{"insecure", "strict", "tofu"}, "default": "insecure"},
+	"Cluster.systemd-restart-sec":    {"default": 5},
+	"Cluster.systemd-limit-nofile":   {"default": 1048576},
+	"Cluster.install-cli-symlinks":   {"default": true},
+	"Cluster.ssh-keepalive-interval": {"default": 30},
+	"Cluster.k3s-version":            {"pattern": k3sVersionPattern.String()},
+	"Cluster.ingress":                {"enum": []string{IngressControllerTraefik, IngressControllerNginx, IngressControllerNone}},
+	"Cluster.disable":                {"items": map[string]any{"enum": disableableComponents}},
+	"Storage.default-class":          {"enum": []string{StorageClassLocalPath, StorageClassLonghorn}, "default": StorageClassLocalPath},
+	"SSH.ciphers":                    {"items": map[string]any{"enum": sshclient.SupportedCiphers}},
+	"SSH.kex":                        {"items": map[string]any{"enum": sshclient.SupportedKeyExchanges}},
+	"SSH.macs":                       {"items": map[string]any{"enum": sshclient.SupportedMACs}},
+	"AssetSource.k3s-binary":         {"default": defaultK3sBinary},
+	"AssetSource.k3s-airgap-tarball": {"default": defaultAirgapTarball},
+	"Node.role":                      {"enum": []string{RoleServer, RoleAgent}},
+	"Node.port":                      {"default": 22},
+	"Node.user":                      {"default": "root"},
+}
+
+// Schema returns a JSON Schema of the config file, generated from the Config
+// type with the defaults and allowed values of its fields. Numbers and
+// booleans may also be strings holding an environment variable reference,
+// which Load expands. The schema checks the shape of a config only: Load
+// still performs the checks across fields.
+func Schema() map[string]any {
+	defs := make(map[string]any)
+	root := schemaObject(reflect.TypeOf(Config{}), defs)
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["title"] = "k3air config"
+	root["$defs"] = defs
+	return root
+}
+
+var unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// schemaType returns the schema of values of type t, adding the schema of
+// every struct type it refers to to defs
+func schemaType(t reflect.Type, defs map[string]any) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	// Types decoding themselves also accept a string: Hook takes a command
+	// and K3sConfig raw YAML
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		other := map[string]any{"type": "object"}
+		if t.Kind() == reflect.Struct {
+			other = schemaRef(t, defs)
+		}
+		return map[string]any{"anyOf": []any{map[string]any{"type": "string"}, other}}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return envOr("boolean")
+	case reflect.Int, reflect.Int64:
+		return envOr("integer")
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaType(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaType(t.Elem(), defs)}
+	case reflect.Struct:
+		return schemaRef(t, defs)
+	}
+	return map[string]any{}
+}
+
+// envOr returns the schema of a value of type typ that may also be written
+// as an environment variable reference
+func envOr(typ string) map[string]any {
+	return map[string]any{"anyOf": []any{
+		map[string]any{"type": typ},
+		map[string]any{"type": "string", "pattern": `\$`},
+	}}
+}
+
+// schemaRef returns a reference to the definition of the struct type t
+func schemaRef(t reflect.Type, defs map[string]any) map[string]any {
+	if _, ok := defs[t.Name()]; !ok {
+		// Claim the name first, the type may refer to itself
+		defs[t.Name()] = nil
+		defs[t.Name()] = schemaObject(t, defs)
+	}
+	return map[string]any{"$ref": "#/$defs/" + t.Name()}
+}
+
+// schemaObject returns the schema of the struct type t, whose fields are
+// the only keys allowed, as Load rejects unknown fields
+func schemaObject(t reflect.Type, defs map[string]any) map[string]any {
+	props := make(map[string]any)
+	for idx := 0; idx < t.NumField(); idx++ {
+		f := t.Field(idx)
+		key, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if key == "" || key == "-" || !f.IsExported() {
+			continue
+		}
+		s := schemaType(f.Type, defs)
+		for k, v := range schemaHints[t.Name()+"."+key] {
+			if k == "items" {
+				items := s["items"].(map[string]any)
+				for ik, iv := range v.(map[string]any) {
+					items[ik] = iv
+				}
+				continue
+			}
+			s[k] = v
+		}
+		props[key] = s
+	}
+	return map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+}
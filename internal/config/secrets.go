@@ -0,0 +1,156 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// readSecretFile returns the contents of a secret file without its trailing
+// newline, rejecting empty files
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimRight(string(b), "\r\n")
+	if v == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return v, nil
+}
+
+// resolveSecret sets *value from the file or the environment variable env
+// when one of them is given. At most one of the three may be set; field names
+// them in errors as field, field-file and field-env.
+func resolveSecret(value *string, file, env, field string) error {
+	set := 0
+	for _, v := range []string{*value, file, env} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("only one of %s, %s-file and %s-env may be set", field, field, field)
+	}
+	switch {
+	case file != "":
+		v, err := readSecretFile(file)
+		if err != nil {
+			return fmt.Errorf("%s-file: %w", field, err)
+		}
+		*value = v
+	case env != "":
+		v := os.Getenv(env)
+		if v == "" {
+			return fmt.Errorf("%s-env: environment variable %s is not set or empty", field, env)
+		}
+		*value = v
+	}
+	return nil
+}
+
+// resolveNodePassword fills node.Password from password_file or password_env
+func resolveNodePassword(node *Node) error {
+	set := 0
+	for _, v := range []string{node.Password, node.PasswordFile, node.PasswordEnv} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("only one of password, password_file and password_env may be set")
+	}
+	switch {
+	case node.PasswordFile != "":
+		v, err := readSecretFile(node.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("password_file: %w", err)
+		}
+		node.Password = v
+	case node.PasswordEnv != "":
+		v := os.Getenv(node.PasswordEnv)
+		if v == "" {
+			return fmt.Errorf("password_env: environment variable %s is not set or empty", node.PasswordEnv)
+		}
+		node.Password = v
+	}
+	return nil
+}
+
+// resolveSecrets replaces the file and environment references of the token,
+// registry credentials and node passwords with their values
+func (c *Config) resolveSecrets() error {
+	if c.Cluster.TokenFile != "" {
+		if c.Cluster.Token != "" {
+			return fmt.Errorf("only one of token and token-file may be set")
+		}
+		v, err := readSecretFile(c.Cluster.TokenFile)
+		if err != nil {
+			return fmt.Errorf("token-file: %w", err)
+		}
+		c.Cluster.Token = v
+	}
+	if err := c.Cluster.resolveRegistrySecrets(); err != nil {
+		return err
+	}
+	for idx := range c.Servers {
+		if err := resolveNodePassword(&c.Servers[idx]); err != nil {
+			return fmt.Errorf("servers[%d] (%s): %w", idx, c.Servers[idx].IP, err)
+		}
+	}
+	for idx := range c.Agents {
+		if err := resolveNodePassword(&c.Agents[idx]); err != nil {
+			return fmt.Errorf("agents[%d] (%s): %w", idx, c.Agents[idx].IP, err)
+		}
+	}
+	return nil
+}
+
+// promptPasswords asks on the terminal for the SSH password of every node
+// that has no other way to authenticate. An empty answer reuses the previous
+// password, so clusters sharing one password only need it typed once.
+func (c *Config) promptPasswords() error {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+	var last string
+	nodes := make([]*Node, 0, len(c.Servers)+len(c.Agents))
+	for idx := range c.Servers {
+		nodes = append(nodes, &c.Servers[idx])
+	}
+	for idx := range c.Agents {
+		nodes = append(nodes, &c.Agents[idx])
+	}
+	for _, node := range nodes {
+		if c.hasCredentials(*node) {
+			continue
+		}
+		user := node.User
+		if user == "" {
+			user = "root"
+		}
+		prompt := fmt.Sprintf("SSH password for %s@%s: ", user, node.IP)
+		if last != "" {
+			prompt = fmt.Sprintf("SSH password for %s@%s (enter to reuse the previous one): ", user, node.IP)
+		}
+		fmt.Fprint(os.Stderr, prompt)
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return fmt.Errorf("failed to read password: %w", err)
+		}
+		pw := string(b)
+		if pw == "" {
+			pw = last
+		}
+		if pw == "" {
+			return fmt.Errorf("no password entered for %s", node.IP)
+		}
+		node.Password = pw
+		last = pw
+	}
+	return nil
+}
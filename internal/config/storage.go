@@ -0,0 +1,103 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"slices"
+)
+
+// Storage classes k3air can make the default
+const (
+	StorageClassLocalPath = "local-path"
+	StorageClassLonghorn  = "longhorn"
+)
+
+// longhornRepo is the Helm repository Longhorn is installed from when no
+// local chart archive is configured
+const longhornRepo = "https://charts.longhorn.io"
+
+// Storage configures the storage classes of the cluster: the local-path
+// provisioner k3s ships, and optionally Longhorn
+type Storage struct {
+	// DefaultClass is the default StorageClass, local-path or longhorn;
+	// local-path, the k3s default, when empty
+	DefaultClass string `yaml:"default-class"`
+	// LocalPathDir is the directory local-path creates volumes in on every
+	// node, instead of <data-dir>/storage
+	LocalPathDir string `yaml:"local-path-dir"`
+	// Longhorn installs Longhorn through the k3s helm controller
+	Longhorn *Longhorn `yaml:"longhorn"`
+}
+
+// Longhorn is the Longhorn chart. Chart is a local .tgz archive for
+// air-gapped installs, or the longhorn chart of the Longhorn repository
+// when empty.
+type Longhorn struct {
+	Chart      string `yaml:"chart"`
+	Version    string `yaml:"version"`
+	ValuesFile string `yaml:"values-file"`
+}
+
+// DefaultStorageClass returns the StorageClass the cluster ends up with as
+// its default
+func (c Cluster) DefaultStorageClass() string {
+	if c.Storage == nil || c.Storage.DefaultClass == "" {
+		if slices.Contains(c.Disabled(), "local-storage") {
+			return ""
+		}
+		return StorageClassLocalPath
+	}
+	return c.Storage.DefaultClass
+}
+
+// Charts returns the configured helm charts, plus Longhorn when enabled
+func (c Cluster) Charts() []HelmChart {
+	charts := c.HelmCharts
+	if c.Storage == nil || c.Storage.Longhorn == nil {
+		return charts
+	}
+	l := c.Storage.Longhorn
+	hc := HelmChart{
+		Name:       "longhorn",
+		Chart:      cmp.Or(l.Chart, "longhorn"),
+		Version:    l.Version,
+		Namespace:  "longhorn-system",
+		ValuesFile: l.ValuesFile,
+	}
+	if !IsLocalChart(hc.Chart) {
+		hc.Repo = longhornRepo
+	}
+	return append(slices.Clone(charts), hc)
+}
+
+// validateStorage checks the storage settings against each other and the
+// disabled k3s components
+func validateStorage(c Cluster) error {
+	s := c.Storage
+	if s == nil {
+		return nil
+	}
+	localStorage := !slices.Contains(c.Disable, "local-storage")
+	switch s.DefaultClass {
+	case "", StorageClassLocalPath:
+		if !localStorage && s.DefaultClass != "" {
+			return fmt.Errorf("storage: default-class is local-path but local-storage is disabled")
+		}
+	case StorageClassLonghorn:
+		if s.Longhorn == nil {
+			return fmt.Errorf("storage: default-class is longhorn but storage.longhorn is not configured")
+		}
+	default:
+		return fmt.Errorf("storage: invalid default-class %q: must be %s or %s", s.DefaultClass, StorageClassLocalPath, StorageClassLonghorn)
+	}
+	if s.LocalPathDir != "" {
+		if !path.IsAbs(s.LocalPathDir) {
+			return fmt.Errorf("storage: local-path-dir must be an absolute path: %s", s.LocalPathDir)
+		}
+		if !localStorage {
+			return fmt.Errorf("storage: local-path-dir is set but local-storage is disabled")
+		}
+	}
+	return nil
+}
@@ -1,13 +1,65 @@
 package config
 
 import (
+	"bytes"
 	"embed"
+	"fmt"
+	"strconv"
+	"text/template"
 )
 
 //go:embed init.yaml.template
 var templateFS embed.FS
 
-// GetTemplate returns the embedded init.yaml.template content
-func GetTemplate() ([]byte, error) {
-	return templateFS.ReadFile("init.yaml.template")
+// TemplateVars are the values filled into init.yaml.template. Empty fields
+// keep the template's examples.
+type TemplateVars struct {
+	// Servers and Agents are node IPs; the first server is the primary
+	Servers    []string
+	Agents     []string
+	Token      string
+	K3sVersion string
+}
+
+// templateNode is a node rendered into the template
+type templateNode struct {
+	Name string
+	IP   string
+}
+
+// GetTemplate returns the embedded init.yaml.template rendered with vars
+func GetTemplate(vars TemplateVars) ([]byte, error) {
+	raw, err := templateFS.ReadFile("init.yaml.template")
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New("init.yaml").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(string(raw))
+	if err != nil {
+		return nil, err
+	}
+	data := struct {
+		Primary    string
+		Servers    []templateNode
+		Agents     []templateNode
+		Token      string
+		K3sVersion string
+	}{Primary: "10.0.0.1", Token: "k3air-token", K3sVersion: vars.K3sVersion}
+	if vars.Token != "" {
+		data.Token = vars.Token
+	}
+	for idx, ip := range vars.Servers {
+		if idx == 0 {
+			data.Primary = ip
+			continue
+		}
+		data.Servers = append(data.Servers, templateNode{Name: fmt.Sprintf("k3s-server-%d", idx), IP: ip})
+	}
+	for idx, ip := range vars.Agents {
+		data.Agents = append(data.Agents, templateNode{Name: fmt.Sprintf("k3s-agent-%d", idx), IP: ip})
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
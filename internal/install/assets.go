@@ -1,17 +1,25 @@
 package install
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/schollz/progressbar/v3"
+	"golang.org/x/term"
+	"k3air/internal/config"
 )
 
 // isURL checks if the given path is a URL
@@ -35,36 +43,217 @@ func getFilenameFromURL(source string) string {
 
 // AssetManager handles asset resolution and cleanup
 type AssetManager struct {
-	tempDir         string
+	tempDir      string
+	client       *http.Client
+	stallTimeout time.Duration
+
+	// mu guards downloadedFiles, downloads, pending, verified and
+	// imageArchives: nodes are installed, and their files uploaded, in
+	// parallel
+	mu              sync.Mutex
 	downloadedFiles []string
+	// downloads maps each downloaded URL to its local file, so an asset
+	// used by several nodes is only downloaded once
+	downloads map[string]string
+	// pending holds the downloads in progress by URL, which other callers
+	// wait for instead of downloading the URL again
+	pending map[string]*pendingDownload
+	// verified holds the local files whose signature has been checked
+	verified map[string]bool
+	// progress is where the download progress bar is drawn, nil for none
+	progress io.Writer
+	// compressed maps local files to their gzip compressed copies.
+	// compressMu serializes Compressed, which holds it while compressing.
+	compressMu sync.Mutex
+	compressed map[string]string
+	// imageArchives holds the local files checked to hold container images
+	imageArchives map[string]bool
+}
+
+// AssetManagerOptions configures how assets are downloaded
+type AssetManagerOptions struct {
+	// Proxy is the proxy URL used for downloads. When empty the standard
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables apply.
+	Proxy string
+	// Timeout limits a whole download; zero means no limit
+	Timeout time.Duration
+	// StallTimeout aborts a download when no data arrives for this long,
+	// including while waiting for the response headers
+	StallTimeout time.Duration
+	// NoProgress disables the download progress bar
+	NoProgress bool
+	// CAFile is a PEM file of root CAs trusted in addition to the system's
+	CAFile string
+	// InsecureSkipTLSVerify disables the verification of HTTPS servers
+	InsecureSkipTLSVerify bool
 }
 
+// Defaults for asset downloads
+const (
+	DefaultDownloadStallTimeout = 30 * time.Second
+	downloadDialTimeout         = 30 * time.Second
+)
+
+// errDownloadStalled is the cancellation cause of a stalled download
+var errDownloadStalled = errors.New("download stalled")
+
 // NewAssetManager creates a new asset manager with a temp directory
-func NewAssetManager() (*AssetManager, error) {
+func NewAssetManager(opts AssetManagerOptions) (*AssetManager, error) {
+	if opts.StallTimeout <= 0 {
+		opts.StallTimeout = DefaultDownloadStallTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.DialContext = (&net.Dialer{Timeout: downloadDialTimeout}).DialContext
+	transport.TLSHandshakeTimeout = downloadDialTimeout
+	transport.ResponseHeaderTimeout = opts.StallTimeout
+	if opts.Proxy != "" {
+		u, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	if err := configureTLS(transport, opts); err != nil {
+		return nil, err
+	}
 	tempDir, err := os.MkdirTemp("", "k3air-assets-*")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temp directory: %w", err)
 	}
+	// Progress bars are unreadable escape noise in logs and pipes
+	var progress io.Writer
+	if !opts.NoProgress && term.IsTerminal(int(os.Stdout.Fd())) {
+		progress = os.Stdout
+	}
 	return &AssetManager{
 		tempDir:         tempDir,
 		downloadedFiles: make([]string, 0),
+		downloads:       make(map[string]string),
+		pending:         make(map[string]*pendingDownload),
+		verified:        make(map[string]bool),
+		progress:        progress,
+		compressed:      make(map[string]string),
+		imageArchives:   make(map[string]bool),
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   opts.Timeout,
+		},
+		stallTimeout: opts.StallTimeout,
 	}, nil
 }
 
-// ResolveAsset returns the local path to use for an asset
+// configureTLS sets up the verification of HTTPS download servers: the
+// system roots plus those of opts.CAFile, or none at all when verification
+// is disabled
+func configureTLS(transport *http.Transport, opts AssetManagerOptions) error {
+	if opts.InsecureSkipTLSVerify {
+		slog.Warn("TLS verification of asset downloads is DISABLED: downloads can be intercepted, remove assets.insecure-skip-tls-verify once the mirror has a trusted certificate")
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		return nil
+	}
+	if opts.CAFile == "" {
+		return nil
+	}
+	pem, err := os.ReadFile(opts.CAFile)
+	if err != nil {
+		return fmt.Errorf("failed to read assets.ca-file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		slog.Debug("system certificate pool unavailable, trusting only the configured CA", "error", err)
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("assets.ca-file %s holds no PEM certificate", opts.CAFile)
+	}
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	slog.Debug("trusting additional CAs for asset downloads", "ca_file", opts.CAFile)
+	return nil
+}
+
+// logProxy logs the proxy a request will go through, without credentials
+func (am *AssetManager) logProxy(req *http.Request) {
+	proxy, err := am.client.Transport.(*http.Transport).Proxy(req)
+	switch {
+	case err != nil:
+		slog.Debug("invalid proxy configuration", "error", err)
+	case proxy == nil:
+		slog.Debug("downloading without proxy", "host", req.URL.Host)
+	default:
+		slog.Debug("downloading through proxy", "host", req.URL.Host, "proxy", proxy.Redacted())
+	}
+}
+
+// ResolveAsset returns the local path to use for an asset. When source
+// cannot be resolved, the mirrors are tried in order; the error lists the
+// failure of every source.
+func (am *AssetManager) ResolveAsset(ctx context.Context, source, description string, mirrors ...string) (string, error) {
+	localPath, err := am.resolve(ctx, source, description)
+	if err == nil || len(mirrors) == 0 {
+		return localPath, err
+	}
+	errs := []error{err}
+	for _, mirror := range mirrors {
+		if ctx.Err() != nil {
+			break
+		}
+		slog.Warn("asset source failed, trying next mirror", "description", description, "error", errs[len(errs)-1], "mirror", mirror)
+		localPath, err := am.resolve(ctx, mirror, description)
+		if err == nil {
+			slog.Info("asset resolved from mirror", "description", description, "mirror", mirror)
+			return localPath, nil
+		}
+		errs = append(errs, err)
+	}
+	return "", fmt.Errorf("no source for %s succeeded:\n%w", description, errors.Join(errs...))
+}
+
+// VerifyAsset checks the local asset at localPath against the detached
+// signature sigSource, a URL or path, with the public key at keyPath. When
+// the check fails a downloaded asset is deleted, so it cannot be used by
+// mistake.
+func (am *AssetManager) VerifyAsset(ctx context.Context, localPath, sigSource, keyPath, description string) error {
+	am.mu.Lock()
+	verified := am.verified[localPath]
+	am.mu.Unlock()
+	if verified {
+		return nil
+	}
+	sigPath, err := am.resolve(ctx, sigSource, description+" signature")
+	if err != nil {
+		return err
+	}
+	err = verifySignature(localPath, sigPath, keyPath)
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	if err != nil {
+		for source, path := range am.downloads {
+			if path == localPath {
+				delete(am.downloads, source)
+				if rmErr := os.Remove(localPath); rmErr != nil {
+					slog.Warn("failed to delete unverified asset", "path", localPath, "error", rmErr)
+				}
+			}
+		}
+		return fmt.Errorf("%s %s: %w", description, localPath, err)
+	}
+	slog.Info("signature verified", "description", description, "signature", sigSource)
+	am.verified[localPath] = true
+	return nil
+}
+
+// resolve returns the local path to use for a single asset source
 // - If source is a local file path that exists, return it as-is
 // - If source is a URL, download to temp dir and return temp path
 // - If source is a local path that doesn't exist, return error with helpful hint
-func (am *AssetManager) ResolveAsset(source, description string) (string, error) {
+func (am *AssetManager) resolve(ctx context.Context, source, description string) (string, error) {
+	source, err := config.FileURLPath(source)
+	if err != nil {
+		return "", err
+	}
 	if isURL(source) {
-		slog.Info("downloading asset", "description", description, "url", source)
-		localPath, err := am.download(source)
-		if err != nil {
-			return "", fmt.Errorf("failed to download %s: %w", description, err)
-		}
-		am.downloadedFiles = append(am.downloadedFiles, localPath)
-		slog.Info("download complete", "path", localPath)
-		return localPath, nil
+		return am.fetch(ctx, source, description)
 	}
 
 	// Local path - check if file exists
@@ -72,19 +261,19 @@ func (am *AssetManager) ResolveAsset(source, description string) (string, error)
 		if os.IsNotExist(err) {
 			// Provide helpful error message based on file type
 			var hint string
-			if source == "k3s" || source == "./k3s" {
+			if filepath.Base(source) == "k3s" {
 				hint = `
 
 Please download k3s binary:
   wget https://github.com/k3s-io/k3s/releases/download/v1.28.5+k3s1/k3s
   chmod +x k3s
-Or configure a URL in your init.yaml under assets.k3s-binary`
-			} else if source == "k3s-airgap-images-amd64.tar.gz" || source == "./k3s-airgap-images-amd64.tar.gz" {
+Or set cluster.k3s-version in your init.yaml to download it, or configure a URL under assets.k3s-binary`
+			} else if filepath.Base(source) == "k3s-airgap-images-amd64.tar.gz" {
 				hint = `
 
 Please download k3s airgap images:
   wget https://github.com/k3s-io/k3s/releases/download/v1.28.5+k3s1/k3s-airgap-images-amd64.tar.gz
-Or configure a URL in your init.yaml under assets.k3s-airgap-tarball`
+Or set cluster.k3s-version in your init.yaml to download it, or configure a URL under assets.k3s-airgap-tarball`
 			}
 			return "", fmt.Errorf("%s file not found: %s%s", description, source, hint)
 		}
@@ -93,59 +282,152 @@ Or configure a URL in your init.yaml under assets.k3s-airgap-tarball`
 	return source, nil
 }
 
-// download downloads a URL to the temp directory with progress bar
-func (am *AssetManager) download(urlStr string) (string, error) {
+// pendingDownload is a download in progress, done is closed once path or
+// err is set
+type pendingDownload struct {
+	done chan struct{}
+	path string
+	err  error
+}
+
+// fetch downloads the URL source once: concurrent callers asking for the
+// same URL wait for the first one's download
+func (am *AssetManager) fetch(ctx context.Context, source, description string) (string, error) {
+	am.mu.Lock()
+	if localPath, ok := am.downloads[source]; ok {
+		am.mu.Unlock()
+		return localPath, nil
+	}
+	if p, ok := am.pending[source]; ok {
+		am.mu.Unlock()
+		select {
+		case <-p.done:
+			return p.path, p.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	p := &pendingDownload{done: make(chan struct{})}
+	am.pending[source] = p
+	am.mu.Unlock()
+
+	slog.Info("downloading asset", "description", description, "url", source)
+	localPath, n, err := am.download(ctx, source)
+	if err != nil {
+		p.err = fmt.Errorf("failed to download %s: %w", description, err)
+	} else {
+		p.path = localPath
+		slog.Info("download complete", "path", localPath, "size", formatBytes(n))
+	}
+	am.mu.Lock()
+	delete(am.pending, source)
+	if err == nil {
+		am.downloadedFiles = append(am.downloadedFiles, localPath)
+		am.downloads[source] = localPath
+	}
+	am.mu.Unlock()
+	close(p.done)
+	return p.path, p.err
+}
+
+// stallReader restarts the stall timer whenever data arrives
+type stallReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.timer.Reset(s.timeout)
+	}
+	return n, err
+}
+
+// download downloads a URL to the temp directory with progress bar and
+// returns the local path and the number of bytes received
+func (am *AssetManager) download(ctx context.Context, urlStr string) (string, int64, error) {
 	filename := getFilenameFromURL(urlStr)
 	if filename == "" {
-		return "", fmt.Errorf("cannot determine filename from URL: %s", urlStr)
+		return "", 0, fmt.Errorf("cannot determine filename from URL: %s", urlStr)
 	}
 
-	localPath := filepath.Join(am.tempDir, filename)
+	// Each download gets its own directory: URLs sharing a basename, such
+	// as two extra images or a source and its mirror, would overwrite each
+	// other, and the basename is kept for the remote name and file type
+	dir, err := os.MkdirTemp(am.tempDir, "download-")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	localPath := filepath.Join(dir, filename)
 
 	// Create file
 	outFile, err := os.Create(localPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
+		return "", 0, fmt.Errorf("failed to create temp file: %w", err)
 	}
 	defer outFile.Close()
 
-	// HTTP GET with timeout
-	client := &http.Client{
-		Timeout: 30 * time.Minute,
+	// The request is cancelled when the body stops delivering data
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stall := time.AfterFunc(am.stallTimeout, func() { cancel(errDownloadStalled) })
+	defer stall.Stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid download URL: %w", err)
 	}
-	resp, err := client.Get(urlStr)
+	am.logProxy(req)
+	resp, err := am.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("download request failed: %w", err)
+		if errors.Is(context.Cause(ctx), errDownloadStalled) {
+			return "", 0, fmt.Errorf("%w: no response for %v", errDownloadStalled, am.stallTimeout)
+		}
+		return "", 0, fmt.Errorf("download request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("download failed with status: %s", resp.Status)
+		return "", 0, fmt.Errorf("download failed with status: %s", resp.Status)
 	}
 
 	// Progress bar for download
 	size := resp.ContentLength
 	var writer io.Writer = outFile
 
-	if size > 0 {
-		bar := progressbar.NewOptions(int(size),
+	var bar *progressbar.ProgressBar
+	if size != 0 && am.progress != nil {
+		// Without a Content-Length, e.g. for a chunked response, size is -1
+		// and the bar is a spinner showing the bytes received so far
+		bar = progressbar.NewOptions64(size,
+			progressbar.OptionSetWriter(am.progress),
 			progressbar.OptionShowBytes(true),
+			progressbar.OptionShowCount(),
 			progressbar.OptionSetDescription("downloading "+filename))
 		writer = io.MultiWriter(outFile, bar)
 	}
 
 	// Copy with progress
-	_, err = io.Copy(writer, resp.Body)
-	if _, ok := writer.(interface{ Flush() }); ok {
-		writer.(interface{ Flush() }).Flush()
+	n, err := io.Copy(writer, &stallReader{r: resp.Body, timer: stall, timeout: am.stallTimeout})
+	if bar != nil {
+		// A failed download keeps the bar where it stopped, and a spinner
+		// has nothing to fill
+		if err == nil && size > 0 {
+			bar.Finish()
+		}
+		fmt.Fprintln(am.progress) // Newline after progress bar
 	}
-	fmt.Println() // Newline after progress bar
 
+	if errors.Is(context.Cause(ctx), errDownloadStalled) {
+		return "", 0, fmt.Errorf("%w: no data received for %v", errDownloadStalled, am.stallTimeout)
+	}
 	if err != nil {
-		return "", fmt.Errorf("download failed: %w", err)
+		return "", 0, fmt.Errorf("download failed: %w", err)
 	}
 
-	return localPath, nil
+	return localPath, n, nil
 }
 
 // Cleanup removes all downloaded files and the temp directory
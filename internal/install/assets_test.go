@@ -0,0 +1,109 @@
+package install
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestAssetManager returns an asset manager drawing its progress bar to
+// progress
+func newTestAssetManager(t *testing.T, progress *bytes.Buffer) *AssetManager {
+	t.Helper()
+	am, err := NewAssetManager(AssetManagerOptions{NoProgress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { am.Cleanup() })
+	am.progress = progress
+	return am
+}
+
+func TestDownloadProgressBar(t *testing.T) {
+	body := bytes.Repeat([]byte("k3s"), 64<<10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	var progress bytes.Buffer
+	am := newTestAssetManager(t, &progress)
+	path, n, err := am.download(context.Background(), srv.URL+"/k3s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(body)) {
+		t.Errorf("downloaded %d bytes, want %d", n, len(body))
+	}
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, body) {
+		t.Errorf("downloaded file differs from the served one (error %v)", err)
+	}
+
+	out := progress.String()
+	if !strings.Contains(out, "downloading k3s") || !strings.Contains(out, "100%") {
+		t.Errorf("progress bar not completed: %q", out)
+	}
+	if !strings.HasSuffix(out, "\n") || strings.Count(out, "\n") != 1 {
+		t.Errorf("want exactly one trailing newline after the bar: %q", out)
+	}
+}
+
+func TestDownloadSpinner(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Flushing before the body is complete sends it chunked, without
+		// a Content-Length
+		w.Write([]byte("k3s"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("k3s"))
+	}))
+	defer srv.Close()
+
+	var progress bytes.Buffer
+	am := newTestAssetManager(t, &progress)
+	if _, _, err := am.download(context.Background(), srv.URL+"/k3s"); err != nil {
+		t.Fatal(err)
+	}
+	out := progress.String()
+	if strings.Contains(out, "%") {
+		t.Errorf("want a spinner without a percentage: %q", out)
+	}
+	if !strings.HasSuffix(out, "\n") || strings.Count(out, "\n") != 1 {
+		t.Errorf("want exactly one trailing newline after the spinner: %q", out)
+	}
+}
+
+func TestResolveSameBasename(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	am := newTestAssetManager(t, new(bytes.Buffer))
+	ctx := context.Background()
+	first, err := am.ResolveAsset(ctx, srv.URL+"/a/redis.tar", "extra image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := am.ResolveAsset(ctx, srv.URL+"/b/redis.tar", "extra image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatalf("both URLs downloaded to %s", first)
+	}
+	for path, want := range map[string]string{first: "/a/redis.tar", second: "/b/redis.tar"} {
+		if got, err := os.ReadFile(path); err != nil || string(got) != want {
+			t.Errorf("%s = %q (error %v), want %q", path, got, err, want)
+		}
+		if filepath.Base(path) != "redis.tar" {
+			t.Errorf("%s does not keep the URL basename", path)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"k3air/internal/sshclient"
+)
+
+// cgroupMemoryArgs enable the memory cgroup controller, which Raspberry Pi
+// OS and some other distributions leave off by default
+const cgroupMemoryArgs = "cgroup_memory=1 cgroup_enable=memory"
+
+// cmdlineFiles are the Raspberry Pi boot files holding the kernel command
+// line, the bookworm location first
+var cmdlineFiles = []string{"/boot/firmware/cmdline.txt", "/boot/cmdline.txt"}
+
+// cgroupMemoryEnabled reports whether the memory cgroup controller is
+// available on c, which k3s refuses to start without
+func cgroupMemoryEnabled(ctx context.Context, c *sshclient.Client, v2 bool) (bool, error) {
+	cmd := `awk '$1 == "memory" { print $4 }' /proc/cgroups`
+	if v2 {
+		cmd = "cat /sys/fs/cgroup/cgroup.controllers"
+	}
+	stdout, stderr, err := c.Run(ctx, cmd)
+	if err != nil {
+		return false, fmt.Errorf("failed to check the memory cgroup: %w: %s", err, strings.TrimSpace(stderr))
+	}
+	if v2 {
+		for _, ctrl := range strings.Fields(stdout) {
+			if ctrl == "memory" {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return strings.TrimSpace(stdout) == "1", nil
+}
+
+// checkCgroups warns when the memory cgroup controller is disabled on c. With
+// cluster.fix-cgroups set, the kernel command line is changed to enable it
+// and the install of the node stops until it has been rebooted.
+func (i *Installer) checkCgroups(ctx context.Context, c *sshclient.Client, info osInfo) error {
+	enabled, err := cgroupMemoryEnabled(ctx, c, info.CgroupV2)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		slog.Debug("memory cgroup enabled", "node", c.Addr())
+		return nil
+	}
+	if !i.cfg.Cluster.FixCgroups {
+		slog.Warn("memory cgroup is disabled, k3s will fail to start: set cluster.fix-cgroups or add "+cgroupMemoryArgs+" to the kernel command line and reboot", "node", c.Addr())
+		return nil
+	}
+	file, err := enableCgroupMemory(ctx, c)
+	if err != nil {
+		return fmt.Errorf("failed to enable the memory cgroup: %w", err)
+	}
+	// k3s cannot start before the reboot, so stop here instead of failing
+	// its health check
+	return fmt.Errorf("enabled the memory cgroup in %s on %s: reboot the node for it to take effect and run apply again", file, c.Addr())
+}
+
+// enableCgroupMemory appends cgroupMemoryArgs to the kernel command line in
+// the Raspberry Pi cmdline.txt or in GRUB_CMDLINE_LINUX, and returns the file
+// it changed. Running it again is a no-op.
+func enableCgroupMemory(ctx context.Context, c *sshclient.Client) (string, error) {
+	for _, f := range cmdlineFiles {
+		if _, _, err := c.Run(ctx, "test -f "+f); err != nil {
+			continue
+		}
+		cmd := fmt.Sprintf("grep -q 'cgroup_enable=memory' %s || sed -i.k3air.bak '1 s/$/ %s/' %s", f, cgroupMemoryArgs, f)
+		return f, runCmd(ctx, c, cmd)
+	}
+	const grub = "/etc/default/grub"
+	if _, _, err := c.Run(ctx, "test -f "+grub); err != nil {
+		return "", fmt.Errorf("no cmdline.txt or %s found to change the kernel command line in", grub)
+	}
+	cmd := fmt.Sprintf(`grep -q '^GRUB_CMDLINE_LINUX=.*cgroup_enable=memory' %s || sed -i.k3air.bak -E 's/^(GRUB_CMDLINE_LINUX="[^"]*)"/\1 %s"/' %s`, grub, cgroupMemoryArgs, grub)
+	if err := runCmd(ctx, c, cmd); err != nil {
+		return "", err
+	}
+	// Debian and Ubuntu ship update-grub, RHEL-likes only grub2-mkconfig
+	update := "if command -v update-grub >/dev/null; then update-grub; " +
+		"elif [ -d /boot/grub2 ]; then grub2-mkconfig -o /boot/grub2/grub.cfg; " +
+		"else grub-mkconfig -o /boot/grub/grub.cfg; fi"
+	return grub, runCmd(ctx, c, update)
+}
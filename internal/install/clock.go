@@ -0,0 +1,122 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"k3air/internal/sshclient"
+)
+
+// DefaultMaxClockSkew is the clock difference between nodes, or between a
+// node and this machine, that preflight tolerates. etcd and TLS certificate
+// validation both break down with larger skews.
+const DefaultMaxClockSkew = 5 * time.Second
+
+// clockSkew returns how far the clock of c is ahead of the local clock. The
+// remote time is compared with the local time halfway through the command,
+// which cancels out most of the round trip.
+func clockSkew(ctx context.Context, c *sshclient.Client) (time.Duration, error) {
+	start := time.Now()
+	stdout, stderr, err := c.Run(ctx, "date +%s.%N")
+	rtt := time.Since(start)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read clock: %w: %s", err, strings.TrimSpace(stderr))
+	}
+	// date without %N support prints it literally, leaving whole seconds
+	secs, frac, _ := strings.Cut(strings.TrimSpace(stdout), ".")
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected date output: %q", stdout)
+	}
+	var nsec int64
+	if n, err := strconv.ParseInt((frac + "000000000")[:9], 10, 64); err == nil {
+		nsec = n
+	}
+	return time.Unix(sec, nsec).Sub(start.Add(rtt / 2)), nil
+}
+
+// describeSkew formats a clock skew as how far a node is ahead or behind
+func describeSkew(skew time.Duration) string {
+	if skew < 0 {
+		return fmt.Sprintf("%v behind", (-skew).Round(time.Millisecond))
+	}
+	return fmt.Sprintf("%v ahead", skew.Round(time.Millisecond))
+}
+
+// maxClockSkew returns Options.MaxClockSkew or its default
+func (i *Installer) maxClockSkew() time.Duration {
+	if i.opts.MaxClockSkew > 0 {
+		return i.opts.MaxClockSkew
+	}
+	return DefaultMaxClockSkew
+}
+
+// checkClock compares the clock of c with the local one and records the skew
+// in report. With Options.FixTime a skewed node's clock is synchronized over
+// NTP first.
+func (i *Installer) checkClock(ctx context.Context, report *PreflightReport, c *sshclient.Client, name string) {
+	skew, err := clockSkew(ctx, c)
+	if err != nil {
+		report.add(name, "clock", false, err.Error())
+		return
+	}
+	limit := i.maxClockSkew()
+	if skew.Abs() > limit && i.opts.FixTime {
+		slog.Warn("clock skewed, synchronizing over NTP (--fix-time)", "node", name, "skew", describeSkew(skew))
+		if err := syncClock(ctx, c); err != nil {
+			report.add(name, "clock", false, fmt.Sprintf("%s of this machine, and synchronizing failed: %v", describeSkew(skew), err))
+			return
+		}
+		if skew, err = clockSkew(ctx, c); err != nil {
+			report.add(name, "clock", false, err.Error())
+			return
+		}
+	}
+	report.skew, report.hasSkew = skew, true
+	if skew.Abs() > limit {
+		report.add(name, "clock", false, fmt.Sprintf("%s of this machine, more than %v: enable NTP on the node or re-run with --fix-time", describeSkew(skew), limit))
+		return
+	}
+	report.add(name, "clock", true, describeSkew(skew)+" of this machine")
+}
+
+// syncClock enables NTP synchronization on c and, with chrony, steps the
+// clock right away instead of slewing it slowly
+func syncClock(ctx context.Context, c *sshclient.Client) error {
+	if err := runCmd(ctx, c, "timedatectl set-ntp true"); err != nil {
+		return err
+	}
+	return runCmd(ctx, c, "if command -v chronyc >/dev/null; then chronyc waitsync 10 && chronyc makestep; else sleep 5; fi")
+}
+
+// checkClockSpread adds a check to report that fails when the clocks of the
+// nodes differ from each other by more than the allowed skew
+func (i *Installer) checkClockSpread(report *PreflightReport, reports []PreflightReport) {
+	var lo, hi time.Duration
+	n := 0
+	for _, r := range reports {
+		if !r.hasSkew {
+			continue
+		}
+		if n == 0 || r.skew < lo {
+			lo = r.skew
+		}
+		if n == 0 || r.skew > hi {
+			hi = r.skew
+		}
+		n++
+	}
+	if n < 2 {
+		return
+	}
+	spread := (hi - lo).Round(time.Millisecond)
+	if limit := i.maxClockSkew(); hi-lo > limit {
+		report.add("all nodes", "clock spread", false, fmt.Sprintf("node clocks differ by %v, more than %v", spread, limit))
+		return
+	}
+	report.add("all nodes", "clock spread", true, fmt.Sprintf("node clocks differ by %v", spread))
+}
@@ -0,0 +1,125 @@
+package install
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k3air/internal/sshclient"
+)
+
+// Compressed returns a gzip compressed copy of localPath in the temp
+// directory, compressing it on first use
+func (am *AssetManager) Compressed(localPath string) (string, error) {
+	am.compressMu.Lock()
+	defer am.compressMu.Unlock()
+	if gz, ok := am.compressed[localPath]; ok {
+		return gz, nil
+	}
+	src, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	gzPath := filepath.Join(am.tempDir, fmt.Sprintf("%d-%s.gz", len(am.compressed), filepath.Base(localPath)))
+	dst, err := os.Create(gzPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create compressed file: %w", err)
+	}
+	defer dst.Close()
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		return "", fmt.Errorf("failed to compress %s: %w", localPath, err)
+	}
+	if err := zw.Close(); err != nil {
+		return "", fmt.Errorf("failed to compress %s: %w", localPath, err)
+	}
+	if err := dst.Close(); err != nil {
+		return "", fmt.Errorf("failed to compress %s: %w", localPath, err)
+	}
+	am.compressed[localPath] = gzPath
+	return gzPath, nil
+}
+
+// fileSHA256 returns the hex SHA-256 digest of the file at path
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sum, err := sha256File(f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// uploadSource returns the local file to upload in place of localPath: its
+// gzip compressed copy when CompressUploads is set and c can decompress and
+// checksum it, otherwise localPath itself
+func (i *Installer) uploadSource(ctx context.Context, c *sshclient.Client, localPath string) (string, bool, error) {
+	if !i.opts.CompressUploads {
+		return localPath, false, nil
+	}
+	if _, _, err := c.Run(ctx, "command -v gunzip && command -v sha256sum"); err != nil {
+		slog.Warn("gunzip or sha256sum missing, uploading uncompressed", "node", c.Addr())
+		return localPath, false, nil
+	}
+	gz, err := i.assetManager.Compressed(localPath)
+	if err != nil {
+		return "", false, err
+	}
+	if info, err := os.Stat(gz); err == nil {
+		slog.Debug("uploading compressed", "file", localPath, "size", formatBytes(info.Size()))
+	}
+	return gz, true, nil
+}
+
+// finishUpload decompresses remotePath.gz into remotePath after a compressed
+// upload and checks that the result matches localPath
+func (i *Installer) finishUpload(ctx context.Context, c *sshclient.Client, localPath, remotePath string, compressed bool) error {
+	if !compressed {
+		return nil
+	}
+	gz := sshclient.ShellQuote(remotePath + ".gz")
+	remote := sshclient.ShellQuote(remotePath)
+	if err := runCmd(ctx, c, "gunzip -c "+gz+" > "+remote+" && rm -f "+gz); err != nil {
+		return fmt.Errorf("failed to decompress %s: %w", remotePath, err)
+	}
+	want, err := fileSHA256(localPath)
+	if err != nil {
+		return err
+	}
+	stdout, stderr, err := c.Run(ctx, "sha256sum "+remote)
+	if err != nil {
+		return fmt.Errorf("failed to checksum %s: %w: %s", remotePath, err, strings.TrimSpace(stderr))
+	}
+	if got := strings.Fields(stdout); len(got) == 0 || got[0] != want {
+		return fmt.Errorf("checksum mismatch for %s after decompression: got %q, want %s", remotePath, strings.TrimSpace(stdout), want)
+	}
+	return nil
+}
+
+// uploadFile uploads localPath to remotePath, gzip compressed in transit
+// when CompressUploads is set
+func (i *Installer) uploadFile(ctx context.Context, c *sshclient.Client, localPath, remotePath string) error {
+	src, compressed, err := i.uploadSource(ctx, c, localPath)
+	if err != nil {
+		return err
+	}
+	target := remotePath
+	if compressed {
+		target += ".gz"
+	}
+	if err := c.UploadResumable(ctx, src, target, !i.opts.NoProgress); err != nil {
+		return err
+	}
+	return i.finishUpload(ctx, c, localPath, remotePath, compressed)
+}
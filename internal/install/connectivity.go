@@ -0,0 +1,189 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k3air/internal/config"
+	"k3air/internal/sshclient"
+)
+
+// probeTimeout is how long, in seconds, a node waits for another node's port
+const probeTimeout = 3
+
+// portProbe is one port a node must reach on another node
+type portProbe struct {
+	target config.Node
+	port   int
+	proto  string
+}
+
+// connectivityProbes returns the ports src must reach on the other nodes:
+// the API server and kubelet, embedded etcd between servers, and the flannel
+// VXLAN port. Targets already running k3s are not probed over UDP, as the
+// listening flannel sends no reply either way.
+func (i *Installer) connectivityProbes(src config.Node, srcServer bool, nodes []config.Node, servers int, installed []bool) []portProbe {
+	cluster := i.cfg.Cluster
+	var probes []portProbe
+	for idx, t := range nodes {
+		if t.IP == src.IP {
+			continue
+		}
+		isServer := idx < servers
+		if isServer {
+			probes = append(probes, portProbe{t, 6443, "tcp"})
+			if srcServer && cluster.DatastoreEndpoint == "" {
+				probes = append(probes, portProbe{t, 2379, "tcp"}, portProbe{t, 2380, "tcp"})
+			}
+		}
+		probes = append(probes, portProbe{t, 10250, "tcp"})
+		if cluster.FlannelBackend == "vxlan" && !installed[idx] {
+			probes = append(probes, portProbe{t, 8472, "udp"})
+		}
+	}
+	return probes
+}
+
+// clusterAddress returns the address node is reached at by the other nodes:
+// its first node_ip, or its SSH address
+func clusterAddress(node config.Node) string {
+	if node.NodeIP != "" {
+		ip, _, _ := strings.Cut(node.NodeIP, ",")
+		return ip
+	}
+	return node.IP
+}
+
+// probeScript returns a shell script probing every port in parallel with
+// bash's /dev/tcp and /dev/udp. It prints one line per probe: its index, the
+// exit code and the error. A refused TCP connection or a port unreachable
+// reply to UDP proves the packets got through before anything listens.
+func probeScript(probes []portProbe) string {
+	var b strings.Builder
+	for idx, p := range probes {
+		dev := fmt.Sprintf("/dev/%s/%s/%d", p.proto, clusterAddress(p.target), p.port)
+		inner := "exec 3<>" + dev
+		if p.proto == "udp" {
+			inner += fmt.Sprintf(" && echo >&3 && read -t %d <&3", probeTimeout-1)
+		}
+		// The unquoted $r joins multi-line errors into the probe's line
+		fmt.Fprintf(&b, "( r=$(timeout %d bash -c %s 2>&1); rc=$?; echo %d $rc $r ) &\n", probeTimeout, sshclient.ShellQuote(inner), idx)
+	}
+	b.WriteString("wait\n")
+	return b.String()
+}
+
+// probeFailure returns why a probe shows the port cannot be reached, or ""
+// when the packets got through
+func probeFailure(p portProbe, code int, msg string) string {
+	switch {
+	case code == 0, strings.Contains(msg, "Connection refused"):
+		return ""
+	case strings.Contains(msg, "No route to host"), strings.Contains(msg, "Network is unreachable"):
+		return "no route"
+	case code == 124 || code > 128:
+		if p.proto == "udp" {
+			return "no reply, likely filtered"
+		}
+		return "timed out, likely filtered"
+	}
+	return strings.TrimSpace(msg)
+}
+
+// checkConnectivity checks from every reachable node that it can reach the
+// k3s ports of the other nodes, so firewalls between nodes surface before
+// the cluster fails to form. Each node's results go to its own report.
+func (i *Installer) checkConnectivity(ctx context.Context, reports []PreflightReport, nodes []config.Node, servers int) {
+	if len(nodes) < 2 {
+		return
+	}
+	usable := make([]bool, len(nodes))
+	installed := make([]bool, len(nodes))
+	for idx := range nodes {
+		usable[idx] = len(reports[idx].unreachable().Results) == 0
+		installed[idx] = i.runsK3s(nodes[idx].IP)
+	}
+	var wg sync.WaitGroup
+	for idx, node := range nodes {
+		if !usable[idx] {
+			continue
+		}
+		wg.Add(1)
+		go func(idx int, node config.Node) {
+			defer wg.Done()
+			probes := i.connectivityProbes(node, idx < servers, nodes, servers, installed)
+			i.probeFrom(ctx, &reports[idx], node, probes)
+		}(idx, node)
+	}
+	wg.Wait()
+}
+
+// probeFrom runs probes on node and reports the unreachable ports grouped by
+// target node
+func (i *Installer) probeFrom(ctx context.Context, report *PreflightReport, node config.Node, probes []portProbe) {
+	name := nodeLabel(node)
+	if len(probes) == 0 {
+		return
+	}
+	c, err := i.connect(ctx, node)
+	if err != nil {
+		report.add(name, "connectivity", false, err.Error())
+		return
+	}
+	defer c.Close()
+	if _, _, err := c.Run(ctx, "command -v bash && command -v timeout"); err != nil {
+		report.add(name, "connectivity", true, "skipped, bash or timeout is not installed")
+		return
+	}
+	stdout, stderr, err := c.Run(ctx, probeScript(probes))
+	if err != nil {
+		report.add(name, "connectivity", false, fmt.Sprintf("failed to probe the other nodes: %v %s", err, strings.TrimSpace(stderr)))
+		return
+	}
+
+	failures := make(map[string][]string)
+	var targets []string
+	seen := 0
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		idx, err1 := strconv.Atoi(fields[0])
+		code, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil || idx < 0 || idx >= len(probes) {
+			continue
+		}
+		seen++
+		msg := ""
+		if len(fields) == 3 {
+			msg = fields[2]
+		}
+		p := probes[idx]
+		if why := probeFailure(p, code, msg); why != "" {
+			target := nodeLabel(p.target)
+			if _, ok := failures[target]; !ok {
+				targets = append(targets, target)
+			}
+			failures[target] = append(failures[target], fmt.Sprintf("%d/%s (%s)", p.port, p.proto, why))
+		}
+	}
+	if seen != len(probes) {
+		report.add(name, "connectivity", false, fmt.Sprintf("only %d of %d port probes reported", seen, len(probes)))
+		return
+	}
+	if len(targets) == 0 {
+		report.add(name, "connectivity", true, fmt.Sprintf("reaches the k3s ports of the other nodes (%d probes)", len(probes)))
+		return
+	}
+	slices.Sort(targets)
+	for _, target := range targets {
+		slices.Sort(failures[target])
+		report.add(name, "connectivity", false, fmt.Sprintf("cannot reach %s on %s: open these ports in the firewalls between the nodes",
+			target, strings.Join(failures[target], ", ")))
+	}
+}
@@ -0,0 +1,164 @@
+package install
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeNode is an SSH server standing in for a node: every command succeeds
+// with the output the test gives it, and files go to an in-memory SFTP file
+// system
+type fakeNode struct {
+	port   int
+	output func(cmd string) string
+	files  sftp.Handlers
+
+	mu       sync.Mutex
+	commands []string
+}
+
+// newFakeNode starts a fake node on localhost accepting any password.
+// output returns the stdout of a command, nil for none.
+func newFakeNode(t *testing.T, output func(cmd string) string) *fakeNode {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) { return nil, nil },
+	}
+	cfg.AddHostKey(hostKey)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	if output == nil {
+		output = func(string) string { return "" }
+	}
+	mem := sftp.InMemHandler()
+	mem.FilePut = mkdirWriter{mem.FilePut, mem.FileCmd}
+	n := &fakeNode{port: l.Addr().(*net.TCPAddr).Port, output: output, files: mem}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go n.serve(conn, cfg)
+		}
+	}()
+	return n
+}
+
+// Commands returns the commands run so far
+func (n *fakeNode) Commands() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.commands...)
+}
+
+// File returns the content of the file at p
+func (n *fakeNode) File(p string) (string, error) {
+	req := sftp.NewRequest("Get", p)
+	req.Flags = 1 // SSH_FXF_READ
+	r, err := n.files.FileGet.Fileread(req)
+	if err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(io.NewSectionReader(r, 0, 1<<30))
+	return string(b), err
+}
+
+func (n *fakeNode) serve(conn net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go n.session(ch, reqs)
+	}
+}
+
+func (n *fakeNode) session(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			ssh.Unmarshal(req.Payload, &payload)
+			req.Reply(true, nil)
+			n.mu.Lock()
+			n.commands = append(n.commands, payload.Command)
+			n.mu.Unlock()
+			io.WriteString(ch, n.output(payload.Command))
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+			return
+		case "subsystem":
+			var payload struct{ Name string }
+			ssh.Unmarshal(req.Payload, &payload)
+			req.Reply(payload.Name == "sftp", nil)
+			if payload.Name == "sftp" {
+				go ssh.DiscardRequests(reqs)
+				sftp.NewRequestServer(ch, n.files).Serve()
+				return
+			}
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// mkdirWriter creates the parent directories of written files, which the
+// in-memory file system starts without
+type mkdirWriter struct {
+	sftp.FileWriter
+	cmd sftp.FileCmder
+}
+
+func (w mkdirWriter) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	dir := path.Dir(r.Filepath)
+	for idx := 1; idx <= len(dir); idx++ {
+		if idx == len(dir) || dir[idx] == '/' {
+			// Existing directories fail to be created, which is fine
+			w.cmd.Filecmd(sftp.NewRequest("Mkdir", dir[:idx]))
+		}
+	}
+	return w.FileWriter.Filewrite(r)
+}
+
+// nodeOutput answers the commands installServer parses the output of
+func nodeOutput(cmd string) string {
+	switch {
+	case strings.HasPrefix(cmd, "cat /etc/os-release"):
+		return "ID=debian\nVERSION_ID=\"12\"\n---\n6.1.0-28-amd64\n---\ncgroup2fs\n"
+	case strings.HasPrefix(cmd, "systemctl is-active"):
+		return "active\n"
+	case strings.HasSuffix(cmd, "--version"):
+		return "k3s version v1.31.4+k3s1 (a562d090)\n"
+	}
+	return ""
+}
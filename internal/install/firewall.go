@@ -0,0 +1,90 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"k3air/internal/sshclient"
+)
+
+// firewallPort is a port (or port range, as "from-to") k3s needs open
+type firewallPort struct {
+	port  string
+	proto string
+}
+
+// requiredPorts returns the inbound ports k3s needs on a server or agent node
+func (i *Installer) requiredPorts(isServer bool) []firewallPort {
+	cluster := i.cfg.Cluster
+	ports := []firewallPort{{"10250", "tcp"}} // kubelet metrics
+	if isServer {
+		ports = append(ports, firewallPort{"6443", "tcp"}) // API server
+		if cluster.DatastoreEndpoint == "" {
+			ports = append(ports, firewallPort{"2379-2380", "tcp"}) // embedded etcd
+		}
+	}
+	switch {
+	case cluster.FlannelBackend == "vxlan":
+		ports = append(ports, firewallPort{"8472", "udp"})
+	case strings.HasPrefix(cluster.FlannelBackend, "wireguard"):
+		ports = append(ports, firewallPort{"51820", "udp"}, firewallPort{"51821", "udp"})
+	}
+	if cluster.EmbeddedRegistry {
+		ports = append(ports, firewallPort{"5001", "tcp"}) // spegel registry mirror
+	}
+	return ports
+}
+
+// configureFirewall opens the ports k3s needs using whichever firewall
+// manager is active on the node. It is a no-op when no firewall is running.
+func (i *Installer) configureFirewall(ctx context.Context, c *sshclient.Client, isServer bool) error {
+	ports := i.requiredPorts(isServer)
+	var cidrs []string
+	for _, list := range []string{i.cfg.Cluster.ClusterCidr, i.cfg.Cluster.ServiceCidr} {
+		for _, cidr := range strings.Split(list, ",") {
+			cidrs = append(cidrs, strings.TrimSpace(cidr))
+		}
+	}
+
+	if _, _, err := c.Run(ctx, "systemctl is-active --quiet firewalld"); err == nil {
+		slog.Info("configuring firewalld", "node", c.Addr())
+		for _, p := range ports {
+			rule := fmt.Sprintf("%s/%s", p.port, p.proto)
+			if err := runCmd(ctx, c, "firewall-cmd --permanent --add-port="+rule); err != nil {
+				return err
+			}
+			slog.Info("firewall rule added", "node", c.Addr(), "port", rule)
+		}
+		// Pod and service traffic must not be filtered between nodes
+		for _, cidr := range cidrs {
+			if err := runCmd(ctx, c, "firewall-cmd --permanent --zone=trusted --add-source="+cidr); err != nil {
+				return err
+			}
+			slog.Info("firewall rule added", "node", c.Addr(), "trusted source", cidr)
+		}
+		return runCmd(ctx, c, "firewall-cmd --reload")
+	}
+
+	if stdout, _, err := c.Run(ctx, "ufw status 2>/dev/null"); err == nil && strings.Contains(stdout, "Status: active") {
+		slog.Info("configuring ufw", "node", c.Addr())
+		for _, p := range ports {
+			rule := fmt.Sprintf("%s/%s", strings.Replace(p.port, "-", ":", 1), p.proto)
+			if err := runCmd(ctx, c, "ufw allow "+rule); err != nil {
+				return err
+			}
+			slog.Info("firewall rule added", "node", c.Addr(), "port", rule)
+		}
+		for _, cidr := range cidrs {
+			if err := runCmd(ctx, c, "ufw allow from "+cidr+" to any"); err != nil {
+				return err
+			}
+			slog.Info("firewall rule added", "node", c.Addr(), "trusted source", cidr)
+		}
+		return nil
+	}
+
+	slog.Debug("no active firewall detected", "node", c.Addr())
+	return nil
+}
@@ -0,0 +1,96 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"k3air/internal/config"
+	"k3air/internal/sshclient"
+
+	"gopkg.in/yaml.v3"
+)
+
+// helmChartManifest renders the HelmChart resource for hc, or a
+// HelmChartConfig when hc only overrides the values of a bundled chart
+func helmChartManifest(hc config.HelmChart) ([]byte, error) {
+	var values string
+	if hc.ValuesFile != "" {
+		b, err := os.ReadFile(hc.ValuesFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read values file: %w", err)
+		}
+		values = string(b)
+	}
+
+	kind := "HelmChart"
+	spec := map[string]interface{}{}
+	switch {
+	case hc.Chart == "":
+		kind = "HelmChartConfig"
+	case config.IsLocalChart(hc.Chart):
+		// Served by the API server from <data-dir>/server/static
+		spec["chart"] = "https://%{KUBERNETES_API}%/static/charts/" + filepath.Base(hc.Chart)
+	default:
+		spec["chart"] = hc.Chart
+		if hc.Repo != "" {
+			spec["repo"] = hc.Repo
+		}
+		if hc.Version != "" {
+			spec["version"] = hc.Version
+		}
+	}
+	if kind == "HelmChart" && hc.Namespace != "" {
+		spec["targetNamespace"] = hc.Namespace
+		spec["createNamespace"] = true
+	}
+	if values != "" {
+		spec["valuesContent"] = values
+	}
+
+	return yaml.Marshal(map[string]interface{}{
+		"apiVersion": "helm.cattle.io/v1",
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name":      hc.Name,
+			"namespace": "kube-system",
+		},
+		"spec": spec,
+	})
+}
+
+// uploadHelmCharts uploads local chart archives and the generated chart
+// resources to the primary server before k3s starts
+func (i *Installer) uploadHelmCharts(ctx context.Context, c *sshclient.Client, dataDir string) error {
+	charts := i.cfg.Cluster.Charts()
+	if len(charts) == 0 {
+		return nil
+	}
+	serverDir := filepath.Join(dataDir, "server")
+	for _, dir := range []string{"manifests", "static/charts"} {
+		if err := c.MkdirAll(ctx, filepath.Join(serverDir, dir)); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+	}
+	for _, hc := range charts {
+		if config.IsLocalChart(hc.Chart) {
+			remote := filepath.Join(serverDir, "static", "charts", filepath.Base(hc.Chart))
+			slog.Info("uploading helm chart archive", "chart", hc.Name, "file", hc.Chart, "path", remote)
+			if err := c.Upload(ctx, hc.Chart, remote, false); err != nil {
+				return fmt.Errorf("failed to upload chart archive %s: %w", hc.Chart, err)
+			}
+		}
+		manifest, err := helmChartManifest(hc)
+		if err != nil {
+			return fmt.Errorf("helm chart %s: %w", hc.Name, err)
+		}
+		remote := filepath.Join(serverDir, "manifests", "k3air-helm-"+hc.Name+".yaml")
+		slog.Info("uploading helm chart manifest", "chart", hc.Name, "path", remote)
+		if err := c.UploadBytes(ctx, manifest, remote); err != nil {
+			return fmt.Errorf("failed to upload helm chart %s: %w", hc.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package install
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"k3air/internal/config"
+	"k3air/internal/sshclient"
+)
+
+// runHooks runs the pre-install or post-install hooks of node in order and
+// logs their output. Script hooks are uploaded to /tmp, made executable so
+// their shebang picks the interpreter, and removed afterwards. A failing
+// hook aborts the node unless it ignores errors.
+func (i *Installer) runHooks(ctx context.Context, c *sshclient.Client, node config.Node, stage string, hooks []config.Hook) error {
+	for idx, h := range hooks {
+		name := fmt.Sprintf("%s hook %d", stage, idx+1)
+		cmd := h.Command
+		if h.Script != "" {
+			local, err := i.assetManager.ResolveAsset(ctx, h.Script, name+" script")
+			if err != nil {
+				return err
+			}
+			script, err := os.ReadFile(local)
+			if err != nil {
+				return fmt.Errorf("failed to read %s script: %w", name, err)
+			}
+			remote := fmt.Sprintf("/tmp/k3air-%s-hook-%d", stage, idx+1)
+			if err := c.UploadBytes(ctx, script, remote); err != nil {
+				return fmt.Errorf("failed to upload %s script: %w", name, err)
+			}
+			quoted := sshclient.ShellQuote(remote)
+			cmd = fmt.Sprintf("chmod 0700 %s && %s; rc=$?; rm -f %s; exit $rc", quoted, quoted, quoted)
+		}
+
+		slog.Info("running hook", "node", nodeLabel(node), "hook", name, "command", cmp.Or(h.Command, h.Script))
+		stdout, stderr, err := c.Run(ctx, cmd)
+		if out := strings.TrimSpace(stdout); out != "" {
+			slog.Info("hook output", "node", nodeLabel(node), "hook", name, "stdout", out)
+		}
+		if out := strings.TrimSpace(stderr); out != "" {
+			slog.Info("hook output", "node", nodeLabel(node), "hook", name, "stderr", out)
+		}
+		if err == nil {
+			continue
+		}
+		if h.IgnoreErrors {
+			slog.Warn("hook failed, continuing as it ignores errors", "node", nodeLabel(node), "hook", name, "error", err)
+			continue
+		}
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	return nil
+}
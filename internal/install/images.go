@@ -0,0 +1,103 @@
+package install
+
+import (
+	"archive/tar"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"k3air/internal/config"
+	"k3air/internal/sshclient"
+)
+
+// checkImageArchive is checkImageArchive for asset files, each checked once
+func (am *AssetManager) checkImageArchive(localPath, name string) error {
+	am.mu.Lock()
+	checked := am.imageArchives[localPath]
+	am.mu.Unlock()
+	if checked {
+		return nil
+	}
+	if err := checkImageArchive(localPath, name); err != nil {
+		return err
+	}
+	am.mu.Lock()
+	am.imageArchives[localPath] = true
+	am.mu.Unlock()
+	return nil
+}
+
+// checkImageArchive checks that the archive at localPath, named name, holds
+// container images: a docker save archive has a manifest.json, an OCI
+// layout an index.json. zstd and lz4 archives are not inspected.
+func checkImageArchive(localPath, name string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	switch {
+	case strings.HasSuffix(name, ".gz"), strings.HasSuffix(name, ".tgz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("not a gzip archive: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	case strings.HasSuffix(name, ".bz2"), strings.HasSuffix(name, ".tbz"):
+		r = bzip2.NewReader(f)
+	case !strings.HasSuffix(name, ".tar"):
+		return nil
+	}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("no manifest.json or index.json, not a docker save or OCI image archive")
+		}
+		if err != nil {
+			return fmt.Errorf("not a tar archive: %w", err)
+		}
+		switch path.Clean(h.Name) {
+		case "manifest.json", "index.json":
+			return nil
+		}
+	}
+}
+
+// uploadExtraImages uploads the extra images archives into the images
+// directory of node, where k3s imports them on startup
+func (i *Installer) uploadExtraImages(ctx context.Context, c *sshclient.Client, node config.Node) error {
+	for _, source := range i.cfg.Cluster.ExtraImages {
+		name := config.ImageArchiveName(source)
+		localPath, err := i.assetManager.ResolveAsset(ctx, source, "extra images "+name)
+		if err != nil {
+			return err
+		}
+		if err := i.assetManager.checkImageArchive(localPath, name); err != nil {
+			return fmt.Errorf("extra images %s: %w", source, err)
+		}
+		info, err := os.Stat(localPath)
+		if err != nil {
+			return fmt.Errorf("failed to stat extra images archive: %w", err)
+		}
+		remote := filepath.Join(i.cfg.DataDir(node), "agent", "images", name)
+		slog.Info("uploading extra images archive", "file", name, "size", formatBytes(info.Size()))
+		if err := c.UploadResumable(ctx, localPath, remote, !i.opts.NoProgress); err != nil {
+			return err
+		}
+		if err := i.verifyUpload(ctx, c, remote, info.Size()); err != nil {
+			return fmt.Errorf("extra images archive upload verification failed: %w", err)
+		}
+	}
+	return nil
+}
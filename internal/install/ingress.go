@@ -0,0 +1,125 @@
+package install
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"k3air/internal/config"
+	"k3air/internal/sshclient"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ingressNginxController is the name of the controller Deployment in the
+// ingress-nginx manifests
+const ingressNginxController = "ingress-nginx-controller"
+
+// ingressNginxHostNetwork turns the controller Deployment of the
+// ingress-nginx manifest into a DaemonSet on the host network, so every node
+// serves ports 80 and 443 directly
+func ingressNginxHostNetwork(manifest []byte) ([]byte, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(manifest))
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	found := false
+	for {
+		var doc map[string]interface{}
+		if err := dec.Decode(&doc); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid ingress-nginx manifest: %w", err)
+		}
+		if doc == nil {
+			continue
+		}
+		if isControllerDeployment(doc) {
+			spec, _ := doc["spec"].(map[string]interface{})
+			podSpec, _ := nested(spec, "template", "spec")
+			if podSpec == nil {
+				return nil, fmt.Errorf("invalid ingress-nginx manifest: %s has no pod template", ingressNginxController)
+			}
+			doc["kind"] = "DaemonSet"
+			delete(spec, "replicas")
+			delete(spec, "strategy")
+			podSpec["hostNetwork"] = true
+			podSpec["dnsPolicy"] = "ClusterFirstWithHostNet"
+			found = true
+		}
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("ingress-nginx manifest has no %s Deployment to run on the host network", ingressNginxController)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// isControllerDeployment reports whether doc is the ingress-nginx
+// controller Deployment
+func isControllerDeployment(doc map[string]interface{}) bool {
+	meta, _ := doc["metadata"].(map[string]interface{})
+	return doc["kind"] == "Deployment" && meta["name"] == ingressNginxController
+}
+
+// nested returns the mapping at keys below m
+func nested(m map[string]interface{}, keys ...string) (map[string]interface{}, bool) {
+	for _, k := range keys {
+		next, ok := m[k].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		m = next
+	}
+	return m, true
+}
+
+// resolveIngress returns the local ingress-nginx manifest, downloading it
+// if needed, or "" when ingress-nginx is not the ingress controller
+func (i *Installer) resolveIngress(ctx context.Context) (string, error) {
+	cluster := i.cfg.Cluster
+	if cluster.IngressController() != config.IngressControllerNginx {
+		return "", nil
+	}
+	return i.assetManager.ResolveAsset(ctx, cluster.IngressNginx.ManifestSource(), "ingress-nginx manifest")
+}
+
+// uploadIngress deploys ingress-nginx through the primary server's
+// manifests directory when it is the configured ingress controller. local
+// is the manifest returned by resolveIngress.
+func (i *Installer) uploadIngress(ctx context.Context, c *sshclient.Client, dataDir, local string) error {
+	cluster := i.cfg.Cluster
+	if cluster.IngressController() != config.IngressControllerNginx {
+		return nil
+	}
+	source := cluster.IngressNginx.ManifestSource()
+	manifest, err := os.ReadFile(local)
+	if err != nil {
+		return err
+	}
+	if cluster.IngressNginx != nil && cluster.IngressNginx.HostNetwork {
+		if manifest, err = ingressNginxHostNetwork(manifest); err != nil {
+			return err
+		}
+	}
+	dir := filepath.Join(dataDir, "server", "manifests")
+	if err := c.MkdirAll(ctx, dir); err != nil {
+		return fmt.Errorf("failed to create manifests directory: %w", err)
+	}
+	remote := filepath.Join(dir, "k3air-ingress-nginx.yaml")
+	slog.Info("uploading ingress-nginx manifest", "source", source, "path", remote)
+	if err := c.UploadBytes(ctx, manifest, remote); err != nil {
+		return fmt.Errorf("failed to upload ingress-nginx manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package install
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// GetKubeconfig downloads the kubeconfig of an installed cluster again, from
+// the primary or the server chosen with Options.KubeconfigFrom. It is
+// written to the configured kubeconfig path, or merged into ~/.kube/config
+// when merge is set.
+func (i *Installer) GetKubeconfig(ctx context.Context, merge bool) error {
+	// The cluster is up, so the kubeconfig is there or not at all
+	data, err := i.fetchKubeconfig(ctx, i.kubeconfigSource(i.cfg.Servers[0]), 0)
+	if err != nil {
+		return err
+	}
+	if !merge {
+		path := i.kubeconfigPath()
+		if err := writeKubeconfig(path, data); err != nil {
+			return err
+		}
+		fmt.Fprintln(i.opts.Output, green("✓ Kubeconfig written to: "+path))
+		return nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to find the home directory: %w", err)
+	}
+	path := filepath.Join(home, ".kube", "config")
+	if i.cfg.Cluster.KubeconfigContext == "" {
+		slog.Warn("merging a kubeconfig whose entries are all named default; set cluster.kubeconfig-context or --kubeconfig-context to keep other clusters' default entries")
+	}
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read kubeconfig: %w", err)
+	}
+	merged, err := mergeKubeconfig(existing, data)
+	if err != nil {
+		return fmt.Errorf("failed to merge kubeconfig into %s: %w", path, err)
+	}
+	if err := writeKubeconfig(path, merged); err != nil {
+		return err
+	}
+	fmt.Fprintln(i.opts.Output, green("✓ Kubeconfig merged into: "+path))
+	return nil
+}
+
+// mergeKubeconfig adds the clusters, users and contexts of add to existing,
+// replacing entries of the same name, and makes add's context the current one
+func mergeKubeconfig(existing, add []byte) ([]byte, error) {
+	var dst, src map[string]interface{}
+	if err := yaml.Unmarshal(existing, &dst); err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(add, &src); err != nil {
+		return nil, err
+	}
+	if dst == nil {
+		return add, nil
+	}
+	for _, section := range []string{"clusters", "users", "contexts"} {
+		entries, _ := dst[section].([]interface{})
+		added, _ := src[section].([]interface{})
+		for _, e := range added {
+			idx := entryIndex(entries, entryName(e))
+			if idx < 0 {
+				entries = append(entries, e)
+			} else {
+				entries[idx] = e
+			}
+		}
+		dst[section] = entries
+	}
+	if current, ok := src["current-context"]; ok {
+		dst["current-context"] = current
+	}
+	return yaml.Marshal(dst)
+}
+
+// entryName returns the name of a kubeconfig cluster, user or context entry
+func entryName(e interface{}) string {
+	m, _ := e.(map[string]interface{})
+	name, _ := m["name"].(string)
+	return name
+}
+
+// entryIndex returns the index of the entry called name, or -1
+func entryIndex(entries []interface{}, name string) int {
+	for idx, e := range entries {
+		if entryName(e) == name {
+			return idx
+		}
+	}
+	return -1
+}
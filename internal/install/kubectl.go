@@ -0,0 +1,150 @@
+package install
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"k3air/internal/config"
+	"k3air/internal/sshclient"
+)
+
+// kubectl runs a kubectl command through the k3s binary on a server node and
+// returns its stdout
+func kubectl(ctx context.Context, c *sshclient.Client, args string) (string, error) {
+	cmd := "/usr/local/bin/k3s kubectl " + args
+	stdout, stderr, err := c.Run(ctx, cmd)
+	if err != nil {
+		return stdout, cmdError(cmd, stdout, stderr, err)
+	}
+	return stdout, nil
+}
+
+// nodeName returns the Kubernetes node name of node: the configured
+// node_name, or the host name k3s registers with when it is unset
+func nodeName(ctx context.Context, c *sshclient.Client, node config.Node) (string, error) {
+	if node.NodeName != "" {
+		return node.NodeName, nil
+	}
+	stdout, _, err := c.Run(ctx, "hostname")
+	if err != nil {
+		return "", fmt.Errorf("failed to determine node name: %w", err)
+	}
+	return strings.TrimSpace(stdout), nil
+}
+
+// apiReadyTimeout is how long waitForAPIReady waits by default
+const apiReadyTimeout = time.Duration(healthCheckMaxRetries) * healthCheckInterval
+
+// waitForAPIReady polls the readyz endpoint of the API server on server until
+// it reports healthy, which includes the embedded etcd member, or timeout
+// has passed
+func waitForAPIReady(ctx context.Context, server *sshclient.Client, timeout time.Duration) error {
+	slog.Info("waiting for API server to be ready", "node", server.Addr(), "timeout", timeout)
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		stdout, err := kubectl(ctx, server, "get --raw /readyz")
+		status := strings.TrimSpace(stdout)
+		if err == nil && status == "ok" {
+			slog.Info("API server is ready", "node", server.Addr())
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			if err == nil {
+				err = fmt.Errorf("readyz reported %q", status)
+			}
+			return fmt.Errorf("API server on %s did not become ready within %v: %w", server.Addr(), timeout, err)
+		}
+		slog.Debug("API server not ready yet", "node", server.Addr(), "status", status, "error", err, "retry", attempt)
+		if err := sleep(ctx, healthCheckInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// waitForNodeReady polls the API server through server until the named node
+// reports the Ready condition
+func waitForNodeReady(ctx context.Context, server *sshclient.Client, name string) error {
+	slog.Info("waiting for node to be Ready", "node", name)
+	args := fmt.Sprintf(`get node %s -o jsonpath='{.status.conditions[?(@.type=="Ready")].status}'`, sshclient.ShellQuote(name))
+	for attempt := 0; attempt < healthCheckMaxRetries; attempt++ {
+		stdout, err := kubectl(ctx, server, args)
+		if err == nil && strings.TrimSpace(stdout) == "True" {
+			slog.Info("node is Ready", "node", name)
+			return nil
+		}
+		slog.Debug("node not Ready yet", "node", name, "status", stdout, "error", err, "retry", attempt+1)
+		if err := sleep(ctx, healthCheckInterval); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("node %s did not become Ready after %v", name, time.Duration(healthCheckMaxRetries)*healthCheckInterval)
+}
+
+// nodeStatus is a cluster node as reported by the API server
+type nodeStatus struct {
+	Name       string
+	Roles      []string
+	Ready      bool
+	Version    string
+	InternalIP string
+}
+
+// clusterNodes lists the nodes of the cluster through server
+func clusterNodes(ctx context.Context, server *sshclient.Client) ([]nodeStatus, error) {
+	stdout, err := kubectl(ctx, server, "get nodes -o json")
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name   string            `json:"name"`
+				Labels map[string]string `json:"labels"`
+			} `json:"metadata"`
+			Status struct {
+				Conditions []struct {
+					Type   string `json:"type"`
+					Status string `json:"status"`
+				} `json:"conditions"`
+				Addresses []struct {
+					Type    string `json:"type"`
+					Address string `json:"address"`
+				} `json:"addresses"`
+				NodeInfo struct {
+					KubeletVersion string `json:"kubeletVersion"`
+				} `json:"nodeInfo"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &list); err != nil {
+		return nil, fmt.Errorf("failed to parse node list: %w", err)
+	}
+	nodes := make([]nodeStatus, 0, len(list.Items))
+	for _, item := range list.Items {
+		n := nodeStatus{Name: item.Metadata.Name, Version: item.Status.NodeInfo.KubeletVersion}
+		for label := range item.Metadata.Labels {
+			if role, ok := strings.CutPrefix(label, "node-role.kubernetes.io/"); ok && role != "" {
+				n.Roles = append(n.Roles, role)
+			}
+		}
+		sort.Strings(n.Roles)
+		for _, cond := range item.Status.Conditions {
+			if cond.Type == "Ready" {
+				n.Ready = cond.Status == "True"
+			}
+		}
+		for _, addr := range item.Status.Addresses {
+			if addr.Type == "InternalIP" && n.InternalIP == "" {
+				n.InternalIP = addr.Address
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(a, b int) bool { return nodes[a].Name < nodes[b].Name })
+	return nodes, nil
+}
@@ -0,0 +1,202 @@
+package install
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"k3air/internal/config"
+	"k3air/internal/sshclient"
+)
+
+// kubeVIPImage is the kube-vip image announcing the API server VIP. Air-gapped
+// installs need it in the airgap images tarball.
+const kubeVIPImage = "ghcr.io/kube-vip/kube-vip:v0.8.9"
+
+// kubeVIPTemplate is a kube-vip DaemonSet running on the servers in ARP mode,
+// with leader election deciding which server holds the VIP
+var kubeVIPTemplate = template.Must(template.New("kube-vip").Parse(`apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: kube-vip
+  namespace: kube-system
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: system:kube-vip-role
+rules:
+  - apiGroups: [""]
+    resources: ["services/status"]
+    verbs: ["update"]
+  - apiGroups: [""]
+    resources: ["services", "endpoints"]
+    verbs: ["list", "get", "watch", "update"]
+  - apiGroups: [""]
+    resources: ["nodes"]
+    verbs: ["list", "get", "watch", "update", "patch"]
+  - apiGroups: ["coordination.k8s.io"]
+    resources: ["leases"]
+    verbs: ["list", "get", "watch", "update", "create"]
+  - apiGroups: ["discovery.k8s.io"]
+    resources: ["endpointslices"]
+    verbs: ["list", "get", "watch", "update"]
+  - apiGroups: [""]
+    resources: ["pods"]
+    verbs: ["list"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: system:kube-vip-binding
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: system:kube-vip-role
+subjects:
+  - kind: ServiceAccount
+    name: kube-vip
+    namespace: kube-system
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: kube-vip-ds
+  namespace: kube-system
+spec:
+  selector:
+    matchLabels:
+      name: kube-vip-ds
+  template:
+    metadata:
+      labels:
+        name: kube-vip-ds
+    spec:
+      affinity:
+        nodeAffinity:
+          requiredDuringSchedulingIgnoredDuringExecution:
+            nodeSelectorTerms:
+              - matchExpressions:
+                  - key: node-role.kubernetes.io/control-plane
+                    operator: Exists
+      containers:
+        - name: kube-vip
+          image: {{ .Image }}
+          args: ["manager"]
+          env:
+            - name: address
+              value: "{{ .Address }}"
+            - name: port
+              value: "6443"
+            - name: vip_arp
+              value: "true"
+            - name: vip_cidr
+              value: "{{ .Bits }}"
+{{- if .Interface }}
+            - name: vip_interface
+              value: "{{ .Interface }}"
+{{- end }}
+            - name: cp_enable
+              value: "true"
+            - name: cp_namespace
+              value: kube-system
+            - name: vip_leaderelection
+              value: "true"
+            - name: vip_leasename
+              value: plndr-cp-lock
+            - name: vip_leaseduration
+              value: "5"
+            - name: vip_renewdeadline
+              value: "3"
+            - name: vip_retryperiod
+              value: "1"
+          securityContext:
+            capabilities:
+              add: ["NET_ADMIN", "NET_RAW"]
+      hostNetwork: true
+      serviceAccountName: kube-vip
+      tolerations:
+        - effect: NoSchedule
+          operator: Exists
+        - effect: NoExecute
+          operator: Exists
+`))
+
+// kubeVIPManifest renders the kube-vip resources announcing vip on iface,
+// or on the interface of the default route when iface is empty
+func kubeVIPManifest(vip, iface string) ([]byte, error) {
+	bits := 32
+	if ip := net.ParseIP(vip); ip != nil && ip.To4() == nil {
+		bits = 128
+	}
+	var buf bytes.Buffer
+	err := kubeVIPTemplate.Execute(&buf, struct {
+		Image, Address, Interface string
+		Bits                      int
+	}{kubeVIPImage, vip, iface, bits})
+	return buf.Bytes(), err
+}
+
+// uploadKubeVIP deploys kube-vip through the primary server's manifests
+// directory when an API VIP is configured
+func (i *Installer) uploadKubeVIP(ctx context.Context, c *sshclient.Client, dataDir string) error {
+	cluster := i.cfg.Cluster
+	if cluster.APIVIP == "" {
+		return nil
+	}
+	manifest, err := kubeVIPManifest(cluster.APIVIP, cluster.APIVIPInterface)
+	if err != nil {
+		return fmt.Errorf("failed to render kube-vip manifest: %w", err)
+	}
+	dir := filepath.Join(dataDir, "server", "manifests")
+	if err := c.MkdirAll(ctx, dir); err != nil {
+		return fmt.Errorf("failed to create manifests directory: %w", err)
+	}
+	remote := filepath.Join(dir, "k3air-kube-vip.yaml")
+	slog.Info("uploading kube-vip manifest", "vip", cluster.APIVIP, "path", remote)
+	if err := c.UploadBytes(ctx, manifest, remote); err != nil {
+		return fmt.Errorf("failed to upload kube-vip manifest: %w", err)
+	}
+	return nil
+}
+
+// apiServerURL returns the URL nodes join the cluster through: the API VIP
+// when configured, otherwise the primary server
+func (i *Installer) apiServerURL(primaryIP string) string {
+	host := primaryIP
+	if i.cfg.Cluster.APIVIP != "" {
+		host = i.cfg.Cluster.APIVIP
+	}
+	return "https://" + net.JoinHostPort(host, "6443")
+}
+
+// waitForVIP waits until the API server answers on the VIP, which requires
+// kube-vip to be scheduled and to have won the leader election
+func (i *Installer) waitForVIP(ctx context.Context, primary config.Node) error {
+	c, err := i.connect(ctx, primary)
+	if err != nil {
+		return fmt.Errorf("failed to connect to primary server: %w", err)
+	}
+	defer c.Close()
+
+	url := i.apiServerURL(primary.IP)
+	slog.Info("waiting for API server VIP", "url", url)
+	for attempt := 0; attempt < healthCheckMaxRetries; attempt++ {
+		stdout, err := kubectl(ctx, c, "--server "+url+" get --raw /readyz")
+		if err == nil && strings.TrimSpace(stdout) == "ok" {
+			slog.Info("API server VIP is ready", "url", url)
+			return nil
+		}
+		slog.Debug("API server VIP not ready yet", "url", url, "status", stdout, "error", err, "retry", attempt+1)
+		if err := sleep(ctx, healthCheckInterval); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("API server VIP %s did not become ready after %v", url, time.Duration(healthCheckMaxRetries)*healthCheckInterval)
+}
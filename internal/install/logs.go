@@ -0,0 +1,65 @@
+package install
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+
+	"k3air/internal/sshclient"
+)
+
+// DefaultLogLines is the number of journal lines Logs prints by default
+const DefaultLogLines = 200
+
+// LogOptions selects the journal lines Logs prints
+type LogOptions struct {
+	// Lines is the number of most recent lines; zero or less prints all
+	Lines int
+	// Since is a journalctl time such as "1h ago" or "2024-01-02 15:04"
+	Since string
+	// Follow keeps printing new lines until ctx is cancelled
+	Follow bool
+}
+
+// journalCommand returns the journalctl command printing the k3s logs
+func journalCommand(opts LogOptions) string {
+	cmd := "journalctl -u k3s -u k3s-agent --no-pager"
+	if opts.Lines > 0 {
+		cmd += " -n " + strconv.Itoa(opts.Lines)
+	}
+	if opts.Since != "" {
+		cmd += " --since " + sshclient.ShellQuote(opts.Since)
+	}
+	if opts.Follow {
+		cmd += " --follow"
+	}
+	return cmd
+}
+
+// Logs streams the k3s journal of the node given by node_name or ip to
+// stdout, and journalctl's errors to stderr
+func (i *Installer) Logs(ctx context.Context, nameOrIP string, opts LogOptions, stdout, stderr io.Writer) error {
+	node, _, err := i.cfg.FindNode(nameOrIP)
+	if err != nil {
+		return err
+	}
+	c, err := i.connect(ctx, node)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	cmd := journalCommand(opts)
+	slog.Debug("fetching k3s logs", "node", nodeLabel(node), "cmd", cmd)
+	err = c.RunStream(ctx, cmd, stdout, stderr)
+	// Following ends with Ctrl-C
+	if opts.Follow && errors.Is(err, context.Canceled) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the k3s journal of %s: %w", nodeLabel(node), err)
+	}
+	return nil
+}
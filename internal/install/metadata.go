@@ -0,0 +1,81 @@
+package install
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"k3air/internal/config"
+	"k3air/internal/sshclient"
+)
+
+// applyNodeMetadata sets the labels and annotations of the installed nodes
+// through the primary server once each node is Ready. Labels are applied
+// again even when they were registered with the node, so restricted labels
+// and later changes to the config take effect. Every node is tried; the
+// failures are returned together.
+func (i *Installer) applyNodeMetadata(ctx context.Context, primary config.Node, nodes []config.Node) error {
+	var pending []config.Node
+	for _, n := range nodes {
+		if len(n.Labels) > 0 || len(n.Annotations) > 0 {
+			pending = append(pending, n)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	pc, err := i.connect(ctx, primary)
+	if err != nil {
+		return fmt.Errorf("failed to connect to primary server: %w", err)
+	}
+	defer pc.Close()
+
+	var errs []error
+	for _, n := range pending {
+		if err := i.labelNode(ctx, pc, n); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", nodeLabel(n), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// labelNode waits for node to be Ready and sets its labels and annotations
+func (i *Installer) labelNode(ctx context.Context, pc *sshclient.Client, node config.Node) error {
+	name := node.NodeName
+	if name == "" {
+		c, err := i.connect(ctx, node)
+		if err != nil {
+			return err
+		}
+		name, err = nodeName(ctx, c, node)
+		c.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := waitForNodeReady(ctx, pc, name); err != nil {
+		return err
+	}
+	for _, step := range []struct {
+		verb  string
+		pairs []string
+	}{
+		{"label", node.Labels},
+		{"annotate", node.Annotations},
+	} {
+		if len(step.pairs) == 0 {
+			continue
+		}
+		args := []string{step.verb, "node", sshclient.ShellQuote(name), "--overwrite"}
+		for _, p := range step.pairs {
+			args = append(args, sshclient.ShellQuote(p))
+		}
+		slog.Info("updating node metadata", "node", name, "action", step.verb, "values", step.pairs)
+		if _, err := kubectl(ctx, pc, strings.Join(args, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package install
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"text/template"
+
+	"k3air/internal/sshclient"
+)
+
+// metalLBPoolTemplate is the MetalLB address pool and its L2 advertisement.
+// k3s keeps re-applying it until the MetalLB CRDs and webhook are up.
+var metalLBPoolTemplate = template.Must(template.New("metallb-pool").Parse(`apiVersion: metallb.io/v1beta1
+kind: IPAddressPool
+metadata:
+  name: k3air-default
+  namespace: metallb-system
+spec:
+  addresses:
+{{- range . }}
+    - {{ . }}
+{{- end }}
+---
+apiVersion: metallb.io/v1beta1
+kind: L2Advertisement
+metadata:
+  name: k3air-default
+  namespace: metallb-system
+spec:
+  ipAddressPools:
+    - k3air-default
+`))
+
+// metalLBPoolManifest renders the address pool handing out addresses
+func metalLBPoolManifest(addresses []string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := metalLBPoolTemplate.Execute(&buf, addresses)
+	return buf.Bytes(), err
+}
+
+// resolveMetalLB returns the local MetalLB manifest, downloading it if
+// needed, or "" when metallb is not configured
+func (i *Installer) resolveMetalLB(ctx context.Context) (string, error) {
+	m := i.cfg.Cluster.MetalLB
+	if m == nil {
+		return "", nil
+	}
+	return i.assetManager.ResolveAsset(ctx, m.ManifestSource(), "metallb manifest")
+}
+
+// uploadMetalLB deploys MetalLB and its address pool through the primary
+// server's manifests directory when metallb is configured. manifest is the
+// local manifest returned by resolveMetalLB.
+func (i *Installer) uploadMetalLB(ctx context.Context, c *sshclient.Client, dataDir, manifest string) error {
+	m := i.cfg.Cluster.MetalLB
+	if m == nil {
+		return nil
+	}
+	pool, err := metalLBPoolManifest(m.Addresses)
+	if err != nil {
+		return fmt.Errorf("failed to render metallb address pool: %w", err)
+	}
+	dir := filepath.Join(dataDir, "server", "manifests")
+	if err := c.MkdirAll(ctx, dir); err != nil {
+		return fmt.Errorf("failed to create manifests directory: %w", err)
+	}
+	remote := filepath.Join(dir, "k3air-metallb.yaml")
+	slog.Info("uploading metallb manifest", "source", m.ManifestSource(), "path", remote)
+	if err := c.Upload(ctx, manifest, remote, false); err != nil {
+		return fmt.Errorf("failed to upload metallb manifest: %w", err)
+	}
+	remote = filepath.Join(dir, "k3air-metallb-pool.yaml")
+	slog.Info("uploading metallb address pool", "addresses", m.Addresses, "path", remote)
+	if err := c.UploadBytes(ctx, pool, remote); err != nil {
+		return fmt.Errorf("failed to upload metallb address pool: %w", err)
+	}
+	return nil
+}
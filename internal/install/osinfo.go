@@ -0,0 +1,109 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"k3air/internal/sshclient"
+)
+
+// Oldest kernel k3air supports; older kernels lack features k3s and its
+// CNI rely on and fail in obscure ways when the service starts
+const (
+	minKernelMajor = 4
+	minKernelMinor = 15
+)
+
+// kernelVersionPattern matches the major and minor version of uname -r
+var kernelVersionPattern = regexp.MustCompile(`^(\d+)\.(\d+)`)
+
+// osInfo describes the operating system of a node
+type osInfo struct {
+	// ID and Version are the ID and VERSION_ID of /etc/os-release, Name its
+	// PRETTY_NAME
+	ID      string
+	Version string
+	Name    string
+	Kernel  string
+	// CgroupV2 is set when /sys/fs/cgroup is the unified cgroup v2 hierarchy
+	CgroupV2 bool
+}
+
+// detectOS reads the distribution, kernel and cgroup version of c
+func detectOS(ctx context.Context, c *sshclient.Client) (osInfo, error) {
+	stdout, stderr, err := c.Run(ctx, "cat /etc/os-release 2>/dev/null; echo ---; uname -r; echo ---; stat -fc %T /sys/fs/cgroup 2>/dev/null || true")
+	if err != nil {
+		return osInfo{}, fmt.Errorf("failed to detect operating system: %w: %s", err, strings.TrimSpace(stderr))
+	}
+	parts := strings.Split(stdout, "---\n")
+	if len(parts) != 3 {
+		return osInfo{}, fmt.Errorf("failed to detect operating system: unexpected output %q", stdout)
+	}
+	info := osInfo{
+		Kernel:   strings.TrimSpace(parts[1]),
+		CgroupV2: strings.TrimSpace(parts[2]) == "cgroup2fs",
+	}
+	for _, line := range strings.Split(parts[0], "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			info.ID = value
+		case "VERSION_ID":
+			info.Version = value
+		case "PRETTY_NAME":
+			info.Name = value
+		}
+	}
+	return info, nil
+}
+
+// problems lists the ways info falls short of what k3air supports
+func (info osInfo) problems() []string {
+	var problems []string
+	if info.ID == "" {
+		problems = append(problems, "unknown distribution: /etc/os-release is missing")
+	}
+	m := kernelVersionPattern.FindStringSubmatch(info.Kernel)
+	if m == nil {
+		problems = append(problems, fmt.Sprintf("unrecognized kernel version %q", info.Kernel))
+	} else {
+		major, _ := strconv.Atoi(m[1])
+		minor, _ := strconv.Atoi(m[2])
+		if major < minKernelMajor || major == minKernelMajor && minor < minKernelMinor {
+			problems = append(problems, fmt.Sprintf("kernel %s is older than %d.%d", info.Kernel, minKernelMajor, minKernelMinor))
+		}
+	}
+	if !info.CgroupV2 {
+		problems = append(problems, "cgroup v2 is not enabled, k3s falls back to the legacy cgroup v1 hierarchy")
+	}
+	return problems
+}
+
+// checkOS logs the operating system of c and warns about an unsupported one,
+// or fails with Options.StrictOS
+func (i *Installer) checkOS(ctx context.Context, c *sshclient.Client) (osInfo, error) {
+	info, err := detectOS(ctx, c)
+	if err != nil {
+		return info, err
+	}
+	slog.Info("node operating system", "node", c.Addr(), "os", info.Name, "id", info.ID, "version", info.Version, "kernel", info.Kernel, "cgroup v2", info.CgroupV2)
+	problems := info.problems()
+	if len(problems) == 0 {
+		return info, nil
+	}
+	if i.opts.StrictOS {
+		return info, fmt.Errorf("unsupported operating system on %s (--strict-os): %s", c.Addr(), strings.Join(problems, "; "))
+	}
+	for _, p := range problems {
+		slog.Warn("unsupported operating system, k3s may fail to start", "node", c.Addr(), "problem", p)
+	}
+	return info, nil
+}
@@ -0,0 +1,347 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k3air/internal/config"
+	"k3air/internal/redact"
+	"k3air/internal/sshclient"
+)
+
+// preflightMinDiskFree is the minimum free space required in the data-dir mount
+const preflightMinDiskFree = 4 << 30 // 4 GiB
+
+// PreflightResult is the outcome of one check on one node
+type PreflightResult struct {
+	Node    string
+	Check   string
+	Passed  bool
+	Message string
+}
+
+// PreflightReport collects the results of all preflight checks
+type PreflightReport struct {
+	Results []PreflightResult
+
+	// skew is the clock skew measured on a single node's report
+	skew    time.Duration
+	hasSkew bool
+}
+
+// Failed returns the results of the checks that did not pass
+func (r *PreflightReport) Failed() []PreflightResult {
+	var failed []PreflightResult
+	for _, res := range r.Results {
+		if !res.Passed {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Error summarizes every failed check, one per line
+func (r *PreflightReport) Error() string {
+	failed := r.Failed()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d preflight check(s) failed:", len(failed))
+	for _, res := range failed {
+		fmt.Fprintf(&b, "\n  - %s: %s: %s", res.Node, res.Check, res.Message)
+	}
+	return b.String()
+}
+
+// unreachable returns a report of the failed ssh and probe checks, the nodes
+// that cannot be installed at all
+func (r *PreflightReport) unreachable() *PreflightReport {
+	var u PreflightReport
+	for _, res := range r.Failed() {
+		if res.Check == "ssh" || res.Check == "probe" {
+			u.Results = append(u.Results, res)
+		}
+	}
+	return &u
+}
+
+func (r *PreflightReport) add(node, check string, passed bool, msg string) {
+	r.Results = append(r.Results, PreflightResult{Node: node, Check: check, Passed: passed, Message: redact.String(msg)})
+}
+
+// Preflight runs the preflight checks on the given nodes and returns the
+// report. The nodes are checked in parallel, each into its own report, and
+// the results are merged in node order. It never changes anything on the
+// nodes, except for synchronizing skewed clocks with Options.FixTime.
+func (i *Installer) Preflight(ctx context.Context, servers, agents []config.Node) *PreflightReport {
+	nodes := append(append([]config.Node{}, servers...), agents...)
+	reports := make([]PreflightReport, len(nodes))
+	var wg sync.WaitGroup
+	for idx, node := range nodes {
+		wg.Add(1)
+		go func(idx int, node config.Node) {
+			defer wg.Done()
+			i.preflightNode(ctx, &reports[idx], node, idx < len(servers))
+		}(idx, node)
+	}
+	wg.Wait()
+	i.checkConnectivity(ctx, reports, nodes, len(servers))
+
+	report := &PreflightReport{}
+	for _, r := range reports {
+		report.Results = append(report.Results, r.Results...)
+	}
+	i.checkClockSpread(report, reports)
+	return report
+}
+
+// runPreflight runs the preflight checks and aborts on failures unless
+// SkipPreflight is set, in which case failures are only logged. Unreachable
+// nodes abort even then, before any node has been changed.
+func (i *Installer) runPreflight(ctx context.Context, servers, agents []config.Node) error {
+	slog.Info("running preflight checks", "nodes", len(servers)+len(agents))
+	report := i.Preflight(ctx, servers, agents)
+	failed := report.Failed()
+	if len(failed) == 0 {
+		slog.Info("preflight checks passed", "checks", len(report.Results))
+		return nil
+	}
+	if !i.opts.SkipPreflight {
+		return fmt.Errorf("%s\nfix the issues above or re-run with --skip-preflight to continue anyway", report.Error())
+	}
+	if unreachable := report.unreachable(); len(unreachable.Results) > 0 {
+		return fmt.Errorf("%s\nall nodes must be reachable before the install starts", unreachable.Error())
+	}
+	for _, res := range failed {
+		slog.Warn("preflight check failed", "node", res.Node, "check", res.Check, "reason", res.Message)
+	}
+	return nil
+}
+
+func (i *Installer) preflightNode(ctx context.Context, report *PreflightReport, node config.Node, isServer bool) {
+	name := nodeLabel(node)
+	status, dialErr := i.dialNode(ctx, node)
+	// With connect retries the node may still be booting
+	if dialErr != nil && i.opts.ConnectRetries <= 1 {
+		report.add(name, "ssh", false, status+": "+dialErr.Error())
+		return
+	}
+	c, err := i.connect(ctx, node)
+	if err != nil {
+		if dialErr != nil {
+			err = fmt.Errorf("%s: %w", status, dialErr)
+		}
+		report.add(name, "ssh", false, err.Error())
+		return
+	}
+	defer c.Close()
+	report.add(name, "ssh", true, "connected")
+	if !i.probeNode(ctx, report, c, node) {
+		return
+	}
+
+	checkSwap(ctx, report, c, name, i.cfg.Cluster.DisableSwap)
+	managed := i.checkExistingInstall(ctx, report, c, node, isServer)
+	ports := []int{10250}
+	if isServer {
+		ports = []int{6443, 10250}
+	}
+	checkPorts(ctx, report, c, name, ports, managed)
+	checkDisk(ctx, report, c, name, i.cfg.DataDir(node))
+	i.checkClock(ctx, report, c, name)
+	i.checkStorage(ctx, report, c, name)
+}
+
+// nodeLabel returns a human readable identifier for node
+func nodeLabel(node config.Node) string {
+	if node.NodeName == "" {
+		return node.IP
+	}
+	return fmt.Sprintf("%s (%s)", node.NodeName, node.IP)
+}
+
+// probeNode runs a trivial command to make sure the node executes commands,
+// and caches its architecture. It reports whether the remaining checks can
+// run.
+func (i *Installer) probeNode(ctx context.Context, report *PreflightReport, c *sshclient.Client, node config.Node) bool {
+	stdout, stderr, err := c.Run(ctx, "echo ok && uname -m")
+	lines := strings.Fields(stdout)
+	if err != nil || len(lines) != 2 || lines[0] != "ok" {
+		report.add(nodeLabel(node), "probe", false, fmt.Sprintf("node does not run commands: %v %s", err, strings.TrimSpace(stderr)))
+		return false
+	}
+	i.setNodeArch(node.IP, lines[1])
+	report.add(nodeLabel(node), "probe", true, "architecture "+lines[1])
+	return true
+}
+
+// setNodeArch caches the machine architecture of the node at ip
+func (i *Installer) setNodeArch(ip, arch string) {
+	i.archMu.Lock()
+	defer i.archMu.Unlock()
+	if i.nodeArch == nil {
+		i.nodeArch = make(map[string]string)
+	}
+	i.nodeArch[ip] = arch
+}
+
+// nodeArchOf returns the cached machine architecture of the node at ip, or
+// "" when it has not been probed
+func (i *Installer) nodeArchOf(ip string) string {
+	i.archMu.Lock()
+	defer i.archMu.Unlock()
+	return i.nodeArch[ip]
+}
+
+// setRunningK3s records that the node at ip runs k3s
+func (i *Installer) setRunningK3s(ip string) {
+	i.runningMu.Lock()
+	defer i.runningMu.Unlock()
+	if i.runningK3s == nil {
+		i.runningK3s = make(map[string]bool)
+	}
+	i.runningK3s[ip] = true
+}
+
+// runsK3s reports whether preflight found k3s running on the node at ip
+func (i *Installer) runsK3s(ip string) bool {
+	i.runningMu.Lock()
+	defer i.runningMu.Unlock()
+	return i.runningK3s[ip]
+}
+
+func checkSwap(ctx context.Context, report *PreflightReport, c *sshclient.Client, name string, willDisable bool) {
+	stdout, _, err := c.Run(ctx, "swapon --show --noheadings 2>/dev/null || true")
+	if err != nil {
+		report.add(name, "swap", false, err.Error())
+		return
+	}
+	if strings.TrimSpace(stdout) != "" {
+		if willDisable {
+			report.add(name, "swap", true, "swap is enabled and will be disabled")
+			return
+		}
+		report.add(name, "swap", false, "swap is enabled, kubelet may misbehave (set cluster.disable-swap: true)")
+		return
+	}
+	report.add(name, "swap", true, "swap is disabled")
+}
+
+// checkPorts checks that the k3s ports are free. On a node already running a
+// k3s installed by k3air, k3s itself holds them.
+func checkPorts(ctx context.Context, report *PreflightReport, c *sshclient.Client, name string, ports []int, managed bool) {
+	stdout, _, err := c.Run(ctx, "ss -ltn")
+	if err != nil {
+		report.add(name, "ports", false, fmt.Sprintf("failed to list listening ports: %v", err))
+		return
+	}
+	listening := make(map[int]bool)
+	for _, line := range strings.Split(stdout, "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		local := fields[3]
+		idx := strings.LastIndex(local, ":")
+		if idx < 0 {
+			continue
+		}
+		if port, err := strconv.Atoi(local[idx+1:]); err == nil {
+			listening[port] = true
+		}
+	}
+	var busy []string
+	for _, p := range ports {
+		if listening[p] {
+			busy = append(busy, strconv.Itoa(p))
+		}
+	}
+	if len(busy) > 0 && managed {
+		report.add(name, "ports", true, "port(s) "+strings.Join(busy, ", ")+" used by the installed k3s")
+		return
+	}
+	if len(busy) > 0 {
+		report.add(name, "ports", false, "port(s) already in use: "+strings.Join(busy, ", "))
+		return
+	}
+	report.add(name, "ports", true, "required ports are free")
+}
+
+func checkDisk(ctx context.Context, report *PreflightReport, c *sshclient.Client, name, dataDir string) {
+	// The data-dir usually does not exist yet, so check the closest existing parent
+	cmd := fmt.Sprintf(`d=%s; while [ ! -e "$d" ]; do d=$(dirname "$d"); done; df -Pk "$d" | awk 'NR==2 {print $4}'`,
+		sshclient.ShellQuote(dataDir))
+	stdout, _, err := c.Run(ctx, cmd)
+	if err != nil {
+		report.add(name, "disk", false, fmt.Sprintf("failed to check free space: %v", err))
+		return
+	}
+	freeKB, err := strconv.ParseInt(strings.TrimSpace(stdout), 10, 64)
+	if err != nil {
+		report.add(name, "disk", false, fmt.Sprintf("unexpected df output: %q", stdout))
+		return
+	}
+	free := freeKB * 1024
+	if free < preflightMinDiskFree {
+		report.add(name, "disk", false, fmt.Sprintf("only %s free for %s, need at least %s",
+			formatBytes(free), dataDir, formatBytes(preflightMinDiskFree)))
+		return
+	}
+	report.add(name, "disk", true, formatBytes(free)+" free")
+}
+
+// checkExistingInstall looks for k3s files on node and reports whether they
+// belong to an install apply takes over: a complete server install, which
+// installServer only updates, an agent recorded in the cluster state, or any
+// leftover of an interrupted install when resuming. Other installs, foreign
+// or half removed, fail the check.
+func (i *Installer) checkExistingInstall(ctx context.Context, report *PreflightReport, c *sshclient.Client, node config.Node, isServer bool) bool {
+	name := nodeLabel(node)
+	serverDir := sshclient.ShellQuote(filepath.Join(i.cfg.DataDir(node), "server"))
+	cmd := `test -x /usr/local/bin/k3s && echo binary
+test -f /etc/systemd/system/k3s.service && echo server-unit
+test -f /etc/systemd/system/k3s-agent.service && echo agent-unit
+test -n "$(ls -A ` + serverDir + ` 2>/dev/null)" && echo server-data
+(systemctl is-active --quiet k3s || systemctl is-active --quiet k3s-agent) && echo active
+true`
+	stdout, _, err := c.Run(ctx, cmd)
+	if err != nil {
+		report.add(name, "existing install", false, fmt.Sprintf("failed to check for an existing install: %v", err))
+		return false
+	}
+	found := make(map[string]bool)
+	for _, f := range strings.Fields(stdout) {
+		found[f] = true
+	}
+	if found["active"] {
+		i.setRunningK3s(node.IP)
+	}
+	if !found["binary"] && !found["server-unit"] && !found["agent-unit"] {
+		report.add(name, "existing install", true, "no existing k3s install")
+		return false
+	}
+	recorded := false
+	if i.opts.State != nil {
+		_, recorded = i.opts.State.FindNode(node.IP)
+	}
+	switch {
+	case isServer && found["binary"] && found["server-unit"] && found["server-data"]:
+		msg := "k3s is installed, only its service config will be updated"
+		if i.opts.Force {
+			msg = "k3s is installed and will be reinstalled because of --force"
+		}
+		report.add(name, "existing install", true, msg)
+	case !isServer && found["binary"] && found["agent-unit"] && recorded:
+		report.add(name, "existing install", true, "k3s agent installed by k3air, it will be reinstalled")
+	case i.opts.Resume:
+		report.add(name, "existing install", true, "files left by the interrupted install, it will be installed again")
+	default:
+		report.add(name, "existing install", false, "k3s is already installed on this node, but not as a complete k3air install: run k3s-uninstall.sh on the node first")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package install
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"k3air/internal/config"
+)
+
+// dialTimeout bounds the TCP probe of a node's SSH port
+const dialTimeout = 3 * time.Second
+
+// dialNode opens and closes a TCP connection to the SSH port of node before
+// the SSH handshake, telling a host that is down from a closed port. It
+// returns a short status and the error when the port cannot be reached.
+// Nodes behind a ProxyJump are not probed, as only the jump host can reach
+// them.
+func (i *Installer) dialNode(ctx context.Context, node config.Node) (string, error) {
+	t, err := i.sshTarget(node)
+	if err != nil || t.proxyJump != "" {
+		// connect reports a broken SSH config
+		return "", nil
+	}
+	addr := net.JoinHostPort(t.host, strconv.Itoa(t.port))
+	d := net.Dialer{Timeout: dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err == nil {
+		conn.Close()
+		return "", nil
+	}
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return "unknown host", fmt.Errorf("cannot resolve %s: %w", t.host, err)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "port closed", fmt.Errorf("nothing listens on %s, check the SSH port and that sshd runs", addr)
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return "host down", fmt.Errorf("no answer from %s within %s, check that the host is up and the IP is right", addr, dialTimeout)
+	}
+	return "unreachable", err
+}
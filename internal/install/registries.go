@@ -0,0 +1,116 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"k3air/internal/config"
+	"k3air/internal/sshclient"
+
+	"gopkg.in/yaml.v3"
+)
+
+// registriesContent returns the registries.yaml to install on every node:
+// the raw registries config when set, otherwise the one rendered from
+// registry-mirrors. It is empty when neither is configured.
+func (i *Installer) registriesContent() ([]byte, error) {
+	cluster := i.cfg.Cluster
+	if cluster.Registries != "" {
+		return []byte(cluster.Registries), nil
+	}
+	if len(cluster.RegistryMirrors) == 0 {
+		return nil, nil
+	}
+	return renderRegistries(cluster.RegistryMirrors)
+}
+
+// registryCertsDir holds the uploaded registry CA certificates
+const registryCertsDir = "/etc/rancher/k3s/certs"
+
+// registryCAPath returns where the CA certificate of registry is uploaded to
+func registryCAPath(registry string) string {
+	return filepath.Join(registryCertsDir, strings.ReplaceAll(registry, ":", "_"), "ca.crt")
+}
+
+// uploadRegistryCAs uploads the CA certificates of the registry mirrors, which
+// registries.yaml refers to
+func (i *Installer) uploadRegistryCAs(ctx context.Context, c *sshclient.Client) error {
+	cluster := i.cfg.Cluster
+	for _, name := range cluster.RegistryNames() {
+		tls := cluster.RegistryMirrors[name].TLS
+		if tls == nil || tls.CAFile == "" {
+			continue
+		}
+		remote := registryCAPath(name)
+		if err := c.MkdirAll(ctx, filepath.Dir(remote)); err != nil {
+			return fmt.Errorf("failed to create registry certs directory: %w", err)
+		}
+		slog.Debug("uploading registry CA certificate", "registry", name, "path", remote)
+		if err := c.Upload(ctx, tls.CAFile, remote, false); err != nil {
+			return fmt.Errorf("failed to upload CA certificate of registry %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// renderRegistries renders registry mirrors in the k3s registries.yaml
+// format. Credentials and TLS settings apply to the hosts that are actually
+// contacted: every endpoint, or the registry itself without endpoints.
+func renderRegistries(mirrors map[string]config.RegistryMirror) ([]byte, error) {
+	type mirror struct {
+		Endpoint []string `yaml:"endpoint,omitempty"`
+	}
+	type auth struct {
+		Username      string `yaml:"username,omitempty"`
+		Password      string `yaml:"password,omitempty"`
+		IdentityToken string `yaml:"identity_token,omitempty"`
+	}
+	type tls struct {
+		CAFile             string `yaml:"ca_file,omitempty"`
+		InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+	}
+	type registryConfig struct {
+		Auth *auth `yaml:"auth,omitempty"`
+		TLS  *tls  `yaml:"tls,omitempty"`
+	}
+	var doc struct {
+		Mirrors map[string]mirror         `yaml:"mirrors"`
+		Configs map[string]registryConfig `yaml:"configs,omitempty"`
+	}
+	doc.Mirrors = make(map[string]mirror)
+	doc.Configs = make(map[string]registryConfig)
+
+	for name, m := range mirrors {
+		doc.Mirrors[name] = mirror{Endpoint: m.Endpoints}
+		if m.Auth == nil && m.TLS == nil {
+			continue
+		}
+		var rc registryConfig
+		if m.Auth != nil {
+			rc.Auth = &auth{Username: m.Auth.Username, Password: m.Auth.Password, IdentityToken: m.Auth.Token}
+		}
+		if m.TLS != nil {
+			rc.TLS = &tls{InsecureSkipVerify: m.TLS.InsecureSkipVerify}
+			if m.TLS.CAFile != "" {
+				rc.TLS.CAFile = registryCAPath(name)
+			}
+		}
+		hosts := []string{name}
+		if len(m.Endpoints) > 0 {
+			hosts = hosts[:0]
+			for _, ep := range m.Endpoints {
+				if u, err := url.Parse(ep); err == nil {
+					hosts = append(hosts, u.Host)
+				}
+			}
+		}
+		for _, h := range hosts {
+			doc.Configs[h] = rc
+		}
+	}
+	return yaml.Marshal(doc)
+}
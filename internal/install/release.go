@@ -0,0 +1,120 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"k3air/internal/config"
+	"k3air/internal/sshclient"
+)
+
+// k3sReleaseURL is the base URL of the k3s GitHub releases
+const k3sReleaseURL = "https://github.com/k3s-io/k3s/releases/download"
+
+// k3sArch maps the machine name reported by uname -m to the architecture
+// name k3s releases use
+func k3sArch(machine string) (string, error) {
+	switch machine {
+	case "x86_64", "amd64":
+		return "amd64", nil
+	case "aarch64", "arm64":
+		return "arm64", nil
+	case "armv7l", "armv7", "armhf":
+		return "arm", nil
+	}
+	return "", fmt.Errorf("no k3s release for architecture %q", machine)
+}
+
+// releaseBinaryName returns the file name of the k3s binary for arch
+func releaseBinaryName(arch string) string {
+	switch arch {
+	case "arm64":
+		return "k3s-arm64"
+	case "arm":
+		return "k3s-armhf"
+	}
+	return "k3s"
+}
+
+// releaseAirgapName returns the file name of the airgap images for arch
+func releaseAirgapName(arch string) string {
+	return "k3s-airgap-images-" + arch + ".tar.gz"
+}
+
+// releaseURL returns the download URL of a k3s release asset
+func releaseURL(version, name string) string {
+	return k3sReleaseURL + "/" + strings.ReplaceAll(version, "+", "%2B") + "/" + name
+}
+
+// releaseSource returns the source of a release asset of k3s-version: a
+// local file of that name in the assets directory when present, so
+// air-gapped installs need no download, otherwise its GitHub release URL
+func (i *Installer) releaseSource(name string) string {
+	local := i.cfg.AssetPath(name)
+	if _, err := os.Stat(local); err == nil {
+		return local
+	}
+	return releaseURL(i.cfg.Cluster.K3sVersion, name)
+}
+
+// archOf returns the k3s architecture of node, as cached by the preflight
+// probe or detected through c
+func (i *Installer) archOf(ctx context.Context, c *sshclient.Client, node config.Node) (string, error) {
+	machine := i.nodeArchOf(node.IP)
+	if machine == "" {
+		stdout, stderr, err := c.Run(ctx, "uname -m")
+		if err != nil {
+			return "", fmt.Errorf("failed to detect architecture: %w: %s", err, strings.TrimSpace(stderr))
+		}
+		machine = strings.TrimSpace(stdout)
+		i.setNodeArch(node.IP, machine)
+	}
+	return k3sArch(machine)
+}
+
+// k3sBinarySources returns the source and mirrors of the k3s binary for
+// node: the configured assets, or the release of k3s-version for the node's
+// architecture when the assets are left at their defaults
+func (i *Installer) k3sBinarySources(ctx context.Context, c *sshclient.Client, node config.Node) (string, []string, error) {
+	if !i.cfg.ReleaseK3sBinary() {
+		return i.cfg.Assets.K3sBinary, i.cfg.Assets.K3sBinaryURLs, nil
+	}
+	arch, err := i.archOf(ctx, c, node)
+	if err != nil {
+		return "", nil, err
+	}
+	return i.releaseSource(releaseBinaryName(arch)), nil, nil
+}
+
+// airgapSources is k3sBinarySources for the airgap images archive
+func (i *Installer) airgapSources(ctx context.Context, c *sshclient.Client, node config.Node) (string, []string, error) {
+	if !i.cfg.ReleaseAirgapTarball() {
+		return i.cfg.Assets.K3sAirgapTarball, i.cfg.Assets.K3sAirgapTarballURLs, nil
+	}
+	arch, err := i.archOf(ctx, c, node)
+	if err != nil {
+		return "", nil, err
+	}
+	return i.releaseSource(releaseAirgapName(arch)), nil, nil
+}
+
+// probedArchs returns the k3s architectures of the probed nodes, amd64 when
+// none has been probed
+func (i *Installer) probedArchs() []string {
+	i.archMu.Lock()
+	defer i.archMu.Unlock()
+	var archs []string
+	for _, machine := range i.nodeArch {
+		if arch, err := k3sArch(machine); err == nil && !slices.Contains(archs, arch) {
+			archs = append(archs, arch)
+		}
+	}
+	if len(archs) == 0 {
+		return []string{"amd64"}
+	}
+	slices.Sort(archs)
+	return archs
+}
@@ -0,0 +1,104 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"k3air/internal/sshclient"
+)
+
+// Reset reinstalls a single configured node in place: the node is removed
+// from the cluster, k3s is uninstalled and its data-dir wiped, and the node
+// is installed again and joined to the existing primary. Resetting the
+// primary server destroys the embedded etcd datastore and therefore requires
+// force.
+func (i *Installer) Reset(ctx context.Context, nameOrIP string, force bool) error {
+	if err := i.requireToken(); err != nil {
+		return err
+	}
+	node, isServer, err := i.cfg.FindNode(nameOrIP)
+	if err != nil {
+		return err
+	}
+	primary := i.cfg.Servers[0]
+	isPrimary := isServer && node.IP == primary.IP
+	if isPrimary {
+		if !force {
+			return fmt.Errorf("refusing to reset the primary server %s: it holds the cluster datastore, every other node would have to be reinstalled; pass --force to reset it anyway", nodeLabel(node))
+		}
+		slog.Warn("resetting the primary server: the cluster is recreated from scratch and the other nodes must be reinstalled", "node", nodeLabel(node))
+	}
+
+	c, err := i.connect(ctx, node)
+	if err != nil {
+		return err
+	}
+	name, err := nodeName(ctx, c, node)
+	if err != nil {
+		c.Close()
+		return err
+	}
+
+	// Remove the node object first so it can register again under the same
+	// name; this also drops a server's etcd membership
+	var pc *sshclient.Client
+	if !isPrimary {
+		pc, err = i.connect(ctx, primary)
+		if err != nil {
+			c.Close()
+			return fmt.Errorf("failed to connect to primary server: %w", err)
+		}
+		defer pc.Close()
+		slog.Info("removing node from the cluster", "node", name)
+		if _, err := kubectl(ctx, pc, "delete node "+sshclient.ShellQuote(name)+" --ignore-not-found"); err != nil {
+			c.Close()
+			return err
+		}
+		if _, err := kubectl(ctx, pc, "-n kube-system delete secret "+sshclient.ShellQuote(name+".node-password.k3s")+" --ignore-not-found"); err != nil {
+			c.Close()
+			return err
+		}
+	}
+
+	slog.Info("uninstalling k3s", "node", name, "ip", node.IP)
+	err = runCmd(ctx, c, "if [ -x /usr/local/bin/k3s-uninstall.sh ]; then /usr/local/bin/k3s-uninstall.sh; fi")
+	if err == nil {
+		err = runCmd(ctx, c, "rm -rf "+sshclient.ShellQuote(i.cfg.DataDir(node)))
+	}
+	c.Close()
+	if err != nil {
+		return fmt.Errorf("failed to uninstall k3s: %w", err)
+	}
+
+	if isServer {
+		slog.Info("reinstalling server", "node", name, "ip", node.IP, "is primary", isPrimary)
+		err = i.installServer(ctx, node, primary.IP, isPrimary)
+	} else {
+		slog.Info("reinstalling agent", "node", name, "ip", node.IP)
+		err = i.installAgent(ctx, node, primary.IP)
+	}
+	if err != nil {
+		return err
+	}
+
+	if pc == nil {
+		pc, err = i.connect(ctx, primary)
+		if err != nil {
+			return fmt.Errorf("failed to connect to primary server: %w", err)
+		}
+		defer pc.Close()
+		if err := waitForAPIReady(ctx, pc, apiReadyTimeout); err != nil {
+			return err
+		}
+	}
+	if err := waitForNodeReady(ctx, pc, name); err != nil {
+		return err
+	}
+	if len(node.Labels) > 0 || len(node.Annotations) > 0 {
+		if err := i.labelNode(ctx, pc, node); err != nil {
+			slog.Warn("failed to set node labels and annotations", "node", name, "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package install
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"k3air/internal/config"
+	"k3air/internal/redact"
+	"k3air/internal/state"
+)
+
+// Node statuses of an ApplyResult
+const (
+	NodePending   = "pending"
+	NodeInstalled = "installed"
+	NodeFailed    = "failed"
+	NodeSkipped   = "skipped"
+)
+
+// ApplyResult is the machine readable outcome of Apply
+type ApplyResult struct {
+	Success    bool         `json:"success"`
+	Duration   Seconds      `json:"duration_seconds"`
+	Kubeconfig string       `json:"kubeconfig,omitempty"`
+	APIServer  string       `json:"api_server,omitempty"`
+	Nodes      []NodeResult `json:"nodes"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// NodeResult is the outcome of one node. Nodes left pending were not
+// reached because an earlier node failed; skipped nodes were already
+// installed by an earlier apply that was resumed.
+type NodeResult struct {
+	Name     string  `json:"name,omitempty"`
+	IP       string  `json:"ip"`
+	Role     string  `json:"role"`
+	Status   string  `json:"status"`
+	Duration Seconds `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+
+	// Phases break Duration down into connect, prepare, upload and
+	// service start, or update for servers that were only updated
+	Phases []PhaseResult `json:"phases,omitempty"`
+}
+
+// PhaseResult is the duration of one install phase of a node
+type PhaseResult struct {
+	Name     string  `json:"name"`
+	Duration Seconds `json:"duration_seconds"`
+}
+
+// Seconds is a duration encoded in JSON as fractional seconds
+type Seconds time.Duration
+
+func (s Seconds) MarshalJSON() ([]byte, error) {
+	return strconv.AppendFloat(nil, time.Duration(s).Round(time.Millisecond).Seconds(), 'f', -1, 64), nil
+}
+
+// addNode adds node to r as pending
+func (r *ApplyResult) addNode(node config.Node, role string) {
+	r.Nodes = append(r.Nodes, NodeResult{Name: node.NodeName, IP: node.IP, Role: role, Status: NodePending})
+}
+
+// addSkipped adds node to r as skipped by a resumed apply, with its role
+// from st
+func (r *ApplyResult) addSkipped(node config.Node, st *state.State) {
+	recorded, _ := st.FindNode(node.IP)
+	r.Nodes = append(r.Nodes, NodeResult{Name: node.NodeName, IP: node.IP, Role: recorded.Role, Status: NodeSkipped})
+}
+
+// track runs install for the node of n and records its duration and outcome
+func (n *NodeResult) track(install func() error) error {
+	start := time.Now()
+	err := install()
+	n.Duration = Seconds(time.Since(start))
+	if err != nil {
+		n.Status = NodeFailed
+		n.Error = redact.String(err.Error())
+		return err
+	}
+	n.Status = NodeInstalled
+	return nil
+}
+
+// nodeErrors returns an error listing every failed node, or nil when none
+// failed
+func (r *ApplyResult) nodeErrors() error {
+	var b strings.Builder
+	failed := 0
+	for _, n := range r.Nodes {
+		if n.Status == NodeFailed {
+			failed++
+			fmt.Fprintf(&b, "\n  - %s (%s): %s", cmp.Or(n.Name, n.IP), n.Role, n.Error)
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d node(s) failed:%s", failed, b.String())
+}
+
+// slowPhase is how long an install phase may take before it is logged as slow
+const slowPhase = 3 * time.Minute
+
+// phaseTimer times the consecutive install phases of a node
+type phaseTimer struct {
+	i     *Installer
+	node  config.Node
+	name  string
+	start time.Time
+}
+
+// newPhaseTimer returns a timer for the phases of node
+func (i *Installer) newPhaseTimer(node config.Node) *phaseTimer {
+	return &phaseTimer{i: i, node: node}
+}
+
+// begin ends the current phase, if any, and starts the phase name
+func (t *phaseTimer) begin(name string) {
+	t.end()
+	t.name = name
+	t.start = time.Now()
+}
+
+// end records the duration of the current phase
+func (t *phaseTimer) end() {
+	if t.name == "" {
+		return
+	}
+	d := time.Since(t.start)
+	if d >= slowPhase {
+		slog.Warn("slow install phase", "node", nodeLabel(t.node), "phase", t.name, "duration", d.Round(time.Second))
+	} else {
+		slog.Debug("install phase done", "node", nodeLabel(t.node), "phase", t.name, "duration", d.Round(time.Millisecond))
+	}
+	t.i.phaseMu.Lock()
+	if t.i.phases == nil {
+		t.i.phases = make(map[string][]PhaseResult)
+	}
+	t.i.phases[t.node.IP] = append(t.i.phases[t.node.IP], PhaseResult{Name: t.name, Duration: Seconds(d)})
+	t.i.phaseMu.Unlock()
+	t.name = ""
+}
+
+// nodePhases returns the phases timed for the node at ip
+func (i *Installer) nodePhases(ip string) []PhaseResult {
+	i.phaseMu.Lock()
+	defer i.phaseMu.Unlock()
+	return i.phases[ip]
+}
@@ -0,0 +1,62 @@
+package install
+
+import (
+	"context"
+	"log/slog"
+
+	"k3air/internal/config"
+	"k3air/internal/state"
+)
+
+// resumeNodes splits the selected nodes for a resumed apply. Nodes the state
+// records as installed in the same role, and whose k3s service is still
+// active, are returned as done; the others are installed again. Without a
+// state nothing is skipped.
+func (i *Installer) resumeNodes(ctx context.Context, servers, agents []config.Node, primaryIP string) (todoServers, todoAgents, done []config.Node) {
+	st := i.opts.State
+	if st == nil || st.Empty() {
+		slog.Info("no installed nodes recorded, nothing to resume")
+		return servers, agents, nil
+	}
+	for _, srv := range servers {
+		if i.stillInstalled(ctx, srv, serverRole(srv.IP == primaryIP)) {
+			done = append(done, srv)
+		} else {
+			todoServers = append(todoServers, srv)
+		}
+	}
+	for _, ag := range agents {
+		if i.stillInstalled(ctx, ag, state.RoleAgent) {
+			done = append(done, ag)
+		} else {
+			todoAgents = append(todoAgents, ag)
+		}
+	}
+	slog.Info("resuming apply", "skipped", len(done), "remaining", len(todoServers)+len(todoAgents))
+	return todoServers, todoAgents, done
+}
+
+// stillInstalled reports whether the state records node as installed with
+// role and its k3s service is active
+func (i *Installer) stillInstalled(ctx context.Context, node config.Node, role string) bool {
+	recorded, ok := i.opts.State.FindNode(node.IP)
+	if !ok || recorded.Role != role {
+		return false
+	}
+	c, err := i.connect(ctx, node)
+	if err != nil {
+		slog.Warn("node recorded as installed is unreachable, installing it again", "node", nodeLabel(node), "error", err)
+		return false
+	}
+	defer c.Close()
+	service := "k3s"
+	if role == state.RoleAgent {
+		service = "k3s-agent"
+	}
+	if _, _, err := c.Run(ctx, "systemctl is-active --quiet "+service); err != nil {
+		slog.Warn("node recorded as installed has no running k3s, installing it again", "node", nodeLabel(node), "service", service)
+		return false
+	}
+	slog.Info("node already installed, skipping", "node", nodeLabel(node))
+	return true
+}
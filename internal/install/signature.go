@@ -0,0 +1,108 @@
+package install
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// errBadSignature is returned when a signature does not match its file
+var errBadSignature = errors.New("signature verification failed")
+
+// verifySignature checks the detached signature at sigPath of the file at
+// path against the public key at keyPath. An armored OpenPGP key verifies a
+// binary or armored OpenPGP signature. A PEM public key verifies a raw or
+// base64 signature of the file's SHA-256 digest, the format of cosign
+// sign-blob and openssl dgst -sha256 -sign.
+func verifySignature(path, sigPath, keyPath string) error {
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read signing key: %w", err)
+	}
+	sig, err := os.ReadFile(sigPath)
+	if err != nil {
+		return fmt.Errorf("failed to read signature: %w", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if bytes.Contains(key, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+		if err != nil {
+			return fmt.Errorf("failed to parse OpenPGP key %s: %w", keyPath, err)
+		}
+		check := openpgp.CheckDetachedSignature
+		if bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN")) {
+			check = openpgp.CheckArmoredDetachedSignature
+		}
+		if _, err := check(keyring, f, bytes.NewReader(sig)); err != nil {
+			return fmt.Errorf("%w: %v", errBadSignature, err)
+		}
+		return nil
+	}
+
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return fmt.Errorf("signing key %s is neither an armored OpenPGP key nor a PEM public key", keyPath)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse signing key %s: %w", keyPath, err)
+	}
+	// cosign writes base64, openssl raw bytes
+	if decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig))); err == nil {
+		sig = decoded
+	}
+
+	var ok bool
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		ok = ed25519.Verify(pub, data, sig)
+	case *ecdsa.PublicKey:
+		digest, err := sha256File(f)
+		if err != nil {
+			return err
+		}
+		ok = ecdsa.VerifyASN1(pub, digest, sig)
+	case *rsa.PublicKey:
+		digest, err := sha256File(f)
+		if err != nil {
+			return err
+		}
+		ok = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig) == nil
+	default:
+		return fmt.Errorf("unsupported signing key type %T", pub)
+	}
+	if !ok {
+		return errBadSignature
+	}
+	return nil
+}
+
+// sha256File returns the SHA-256 digest of the rest of r
+func sha256File(r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,63 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"k3air/internal/config"
+	"k3air/internal/sshclient"
+	"k3air/internal/state"
+)
+
+// k3sVersion returns the version of the k3s binary installed on c, such as
+// v1.31.4+k3s1
+func k3sVersion(ctx context.Context, c *sshclient.Client) (string, error) {
+	return binaryVersion(ctx, c, "/usr/local/bin/k3s")
+}
+
+// binaryVersion returns the version reported by the k3s binary at path on c
+func binaryVersion(ctx context.Context, c *sshclient.Client, path string) (string, error) {
+	stdout, stderr, err := c.Run(ctx, sshclient.ShellQuote(path)+" --version")
+	if err != nil {
+		return "", fmt.Errorf("failed to get k3s version: %w: %s", err, strings.TrimSpace(stderr))
+	}
+	// k3s version v1.31.4+k3s1 (a562d090)
+	fields := strings.Fields(stdout)
+	if len(fields) < 3 || fields[1] != "version" {
+		return "", fmt.Errorf("unexpected k3s version output: %q", strings.TrimSpace(stdout))
+	}
+	return fields[2], nil
+}
+
+// recordNode stores node in the cluster state, when one is kept. Failing to
+// determine the details is not fatal: the node is installed either way.
+func (i *Installer) recordNode(ctx context.Context, c *sshclient.Client, node config.Node, role string) {
+	st := i.opts.State
+	if st == nil {
+		return
+	}
+	name, err := nodeName(ctx, c, node)
+	if err != nil {
+		slog.Warn("failed to record node in state", "node", nodeLabel(node), "error", err)
+		return
+	}
+	version, err := k3sVersion(ctx, c)
+	if err != nil {
+		slog.Warn("failed to record node in state", "node", nodeLabel(node), "error", err)
+		return
+	}
+	st.SetNode(state.Node{Name: name, IP: node.IP, Role: role, K3sVersion: version})
+	if role == state.RolePrimary {
+		st.K3sVersion = version
+	}
+}
+
+// serverRole returns the state role of a server
+func serverRole(isPrimary bool) string {
+	if isPrimary {
+		return state.RolePrimary
+	}
+	return state.RoleServer
+}
@@ -0,0 +1,93 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"k3air/internal/config"
+	"k3air/internal/sshclient"
+)
+
+// localStorageSkip returns the file that stops k3s from re-applying its
+// bundled local-path StorageClass, which would make it the default again
+func localStorageSkip(dataDir string) string {
+	return filepath.Join(dataDir, "server", "manifests", "local-storage.yaml.skip")
+}
+
+// longhornDefault reports whether Longhorn replaces local-path as the
+// default StorageClass
+func (i *Installer) longhornDefault() bool {
+	return i.cfg.Cluster.DefaultStorageClass() == config.StorageClassLonghorn
+}
+
+// uploadLocalStorageSkip keeps a joining server from restoring local-path as
+// the default StorageClass after the primary changed it
+func (i *Installer) uploadLocalStorageSkip(ctx context.Context, c *sshclient.Client, dataDir string) error {
+	if !i.longhornDefault() {
+		return nil
+	}
+	if err := c.MkdirAll(ctx, filepath.Dir(localStorageSkip(dataDir))); err != nil {
+		return fmt.Errorf("failed to create manifests directory: %w", err)
+	}
+	return c.UploadBytes(ctx, nil, localStorageSkip(dataDir))
+}
+
+// setDefaultStorageClass makes Longhorn the only default StorageClass by
+// clearing the default flag of local-path once k3s has created it on the
+// primary
+func (i *Installer) setDefaultStorageClass(ctx context.Context, primary config.Node) error {
+	if !i.longhornDefault() {
+		return nil
+	}
+	c, err := i.connect(ctx, primary)
+	if err != nil {
+		return fmt.Errorf("failed to connect to primary server: %w", err)
+	}
+	defer c.Close()
+
+	slog.Info("making longhorn the default StorageClass")
+	patch := `'{"metadata":{"annotations":{"storageclass.kubernetes.io/is-default-class":"false"}}}'`
+	for attempt := 0; ; attempt++ {
+		_, err = kubectl(ctx, c, "patch storageclass local-path -p "+patch)
+		if err == nil {
+			break
+		}
+		if attempt == healthCheckMaxRetries {
+			return fmt.Errorf("failed to unset local-path as the default StorageClass after %v: %w",
+				time.Duration(healthCheckMaxRetries)*healthCheckInterval, err)
+		}
+		slog.Debug("local-path StorageClass not created yet", "retry", attempt+1)
+		if err := sleep(ctx, healthCheckInterval); err != nil {
+			return err
+		}
+	}
+	return i.uploadLocalStorageSkip(ctx, c, i.cfg.DataDir(primary))
+}
+
+// checkStorage checks that the local-path directory can be created and that
+// nodes can attach Longhorn volumes, which needs iscsiadm
+func (i *Installer) checkStorage(ctx context.Context, report *PreflightReport, c *sshclient.Client, name string) {
+	s := i.cfg.Cluster.Storage
+	if s == nil {
+		return
+	}
+	if s.LocalPathDir != "" {
+		// The closest existing parent must be a directory root can write to
+		cmd := fmt.Sprintf(`d=%s; while [ ! -e "$d" ]; do d=$(dirname "$d"); done; test -d "$d" && test -w "$d"`,
+			sshclient.ShellQuote(s.LocalPathDir))
+		if _, _, err := c.Run(ctx, cmd); err != nil {
+			report.add(name, "storage", false, fmt.Sprintf("local-path-dir %s cannot be created", s.LocalPathDir))
+			return
+		}
+	}
+	if s.Longhorn != nil {
+		if _, _, err := c.Run(ctx, "command -v iscsiadm"); err != nil {
+			report.add(name, "storage", false, "longhorn needs open-iscsi, but iscsiadm is not installed")
+			return
+		}
+	}
+	report.add(name, "storage", true, "storage requirements met")
+}
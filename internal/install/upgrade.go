@@ -0,0 +1,259 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"k3air/internal/config"
+	"k3air/internal/sshclient"
+	"k3air/internal/state"
+
+	"github.com/schollz/progressbar/v3"
+	"golang.org/x/term"
+)
+
+// stagedK3sPath is where the new binary is uploaded to: the running binary
+// cannot be overwritten in place, so it is uploaded next to it and renamed
+const stagedK3sPath = "/usr/local/bin/k3s.new"
+
+// Upgrade rolls a new k3s binary out to every node. Agents are upgraded
+// first, up to concurrency at a time; servers follow one by one with the
+// primary last. Each node is drained, gets the new binary, is restarted and
+// must report Ready before the rollout continues. The first failure aborts
+// the rollout and leaves the remaining nodes untouched. When
+// assets.signing-key is set, the binary is verified against sig before any
+// node is touched.
+func (i *Installer) Upgrade(ctx context.Context, binary, sig string, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	key := i.cfg.Assets.SigningKey
+	if key != "" && sig == "" {
+		return fmt.Errorf("assets.signing-key is set: pass the signature of the new k3s binary with --k3s-binary-sig")
+	}
+	if key == "" && sig != "" {
+		return fmt.Errorf("--k3s-binary-sig needs assets.signing-key to verify the signature with")
+	}
+	binPath, err := i.assetManager.ResolveAsset(ctx, binary, "k3s binary")
+	if err != nil {
+		return err
+	}
+	if sig != "" {
+		if err := i.assetManager.VerifyAsset(ctx, binPath, sig, key, "k3s binary"); err != nil {
+			return err
+		}
+	}
+
+	primary := i.cfg.Servers[0]
+	pc, err := i.connect(ctx, primary)
+	if err != nil {
+		return fmt.Errorf("failed to connect to primary server: %w", err)
+	}
+	defer pc.Close()
+
+	drain := len(i.cfg.Servers)+len(i.cfg.Agents) > 1
+
+	for start := 0; start < len(i.cfg.Agents); start += concurrency {
+		end := min(start+concurrency, len(i.cfg.Agents))
+		batch := i.cfg.Agents[start:end]
+		// Parallel uploads share one aggregate progress bar instead of
+		// interleaving a bar per node
+		staged := len(batch) > 1
+		if staged {
+			if err := i.stageBinary(ctx, batch, binPath); err != nil {
+				return err
+			}
+		}
+		errs := make([]error, len(batch))
+		var wg sync.WaitGroup
+		for idx, ag := range batch {
+			wg.Add(1)
+			go func(idx int, ag config.Node) {
+				defer wg.Done()
+				errs[idx] = i.upgradeNode(ctx, pc, ag, binPath, false, drain, staged)
+			}(idx, ag)
+		}
+		wg.Wait()
+		for idx, err := range errs {
+			if err != nil {
+				return fmt.Errorf("upgrade of agent %s failed, aborting rollout: %w", nodeLabel(batch[idx]), err)
+			}
+		}
+	}
+
+	// Servers one at a time to keep etcd quorum, the primary last so kubectl
+	// stays available for as long as possible
+	servers := append(append([]config.Node{}, i.cfg.Servers[1:]...), primary)
+	for _, srv := range servers {
+		if err := i.upgradeNode(ctx, pc, srv, binPath, true, drain, false); err != nil {
+			return fmt.Errorf("upgrade of server %s failed, aborting rollout: %w", nodeLabel(srv), err)
+		}
+	}
+
+	slog.Info("upgrade completed", "nodes", len(i.cfg.Servers)+len(i.cfg.Agents))
+	return nil
+}
+
+// upgradeNode drains node, swaps in the new binary, restarts k3s and waits
+// until the node is Ready again before uncordoning it. When staged is set the
+// binary has already been uploaded by stageBinary.
+func (i *Installer) upgradeNode(ctx context.Context, pc *sshclient.Client, node config.Node, binPath string, isServer, drain, staged bool) error {
+	c, err := i.connect(ctx, node)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	name, err := nodeName(ctx, c, node)
+	if err != nil {
+		return err
+	}
+	slog.Info("upgrading node", "node", name, "ip", node.IP, "server", isServer)
+
+	if drain {
+		slog.Info("draining node", "node", name)
+		if _, err := kubectl(ctx, pc, "drain "+sshclient.ShellQuote(name)+" --ignore-daemonsets --delete-emptydir-data --timeout=5m"); err != nil {
+			return err
+		}
+	}
+
+	info, err := os.Stat(binPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat k3s binary: %w", err)
+	}
+	if !staged {
+		slog.Info("uploading k3s binary", "size", formatBytes(info.Size()), "node", c.Addr())
+		if err := i.uploadFile(ctx, c, binPath, stagedK3sPath); err != nil {
+			return err
+		}
+	}
+	if err := i.verifyUpload(ctx, c, stagedK3sPath, info.Size()); err != nil {
+		return fmt.Errorf("k3s binary upload verification failed: %w", err)
+	}
+	// Run the new binary before it replaces the working one
+	if err := runCmd(ctx, c, "chmod +x "+stagedK3sPath); err != nil {
+		return err
+	}
+	if err := i.verifyK3sBinary(ctx, c, node, stagedK3sPath); err != nil {
+		return err
+	}
+	if err := runCmd(ctx, c, "mv -f "+stagedK3sPath+" /usr/local/bin/k3s"); err != nil {
+		return err
+	}
+
+	// Links keep following the new binary; this also replaces the copied
+	// kubectl of clusters installed by older versions
+	if err := i.installCLISymlinks(ctx, c, isServer); err != nil {
+		return err
+	}
+	service := "k3s-agent"
+	if isServer {
+		service = "k3s"
+	}
+	slog.Info("restarting service", "service", service, "node", name)
+	if err := i.runCmdRetry(ctx, c, "systemctl restart "+service); err != nil {
+		return err
+	}
+	if err := i.waitForServiceReady(ctx, c, service); err != nil {
+		return err
+	}
+	if err := waitForNodeReady(ctx, pc, name); err != nil {
+		return err
+	}
+
+	if drain {
+		if _, err := kubectl(ctx, pc, "uncordon "+sshclient.ShellQuote(name)); err != nil {
+			return err
+		}
+	}
+	role := state.RoleAgent
+	switch {
+	case node.IP == i.cfg.Servers[0].IP:
+		role = state.RolePrimary
+	case isServer:
+		role = state.RoleServer
+	}
+	i.recordNode(ctx, c, node, role)
+	slog.Info("node upgraded", "node", name)
+	return nil
+}
+
+// stageBinary uploads the k3s binary to all nodes at the same time, rendering
+// a single progress bar for the total number of bytes. Connections are opened
+// before the bar is drawn and nothing is logged while it is active, so log
+// lines and the bar do not clobber each other.
+func (i *Installer) stageBinary(ctx context.Context, nodes []config.Node, binPath string) error {
+	info, err := os.Stat(binPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat k3s binary: %w", err)
+	}
+	clients := make([]*sshclient.Client, 0, len(nodes))
+	defer func() {
+		for _, c := range clients {
+			c.Close()
+		}
+	}()
+	for _, node := range nodes {
+		c, err := i.connect(ctx, node)
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s: %w", nodeLabel(node), err)
+		}
+		clients = append(clients, c)
+	}
+	sources := make([]string, len(clients))
+	compressed := make([]bool, len(clients))
+	var total int64
+	for idx, c := range clients {
+		if sources[idx], compressed[idx], err = i.uploadSource(ctx, c, binPath); err != nil {
+			return err
+		}
+		src, err := os.Stat(sources[idx])
+		if err != nil {
+			return err
+		}
+		total += src.Size()
+	}
+
+	slog.Info("uploading k3s binary", "size", formatBytes(info.Size()), "nodes", len(nodes))
+	var bar *progressbar.ProgressBar
+	var progress io.Writer
+	if !i.opts.NoProgress && term.IsTerminal(int(os.Stdout.Fd())) {
+		bar = progressbar.NewOptions64(total,
+			progressbar.OptionShowBytes(true),
+			progressbar.OptionSetDescription(fmt.Sprintf("upload k3s to %d nodes", len(nodes))),
+			progressbar.OptionClearOnFinish())
+		progress = bar
+	}
+	errs := make([]error, len(clients))
+	var wg sync.WaitGroup
+	for idx, c := range clients {
+		wg.Add(1)
+		go func(idx int, c *sshclient.Client) {
+			defer wg.Done()
+			target := stagedK3sPath
+			if compressed[idx] {
+				target += ".gz"
+			}
+			errs[idx] = c.UploadWithProgress(ctx, sources[idx], target, progress)
+		}(idx, c)
+	}
+	wg.Wait()
+	if bar != nil {
+		bar.Finish()
+	}
+	for idx, err := range errs {
+		if err != nil {
+			return fmt.Errorf("failed to upload k3s binary to %s: %w", nodeLabel(nodes[idx]), err)
+		}
+	}
+	for idx, c := range clients {
+		if err := i.finishUpload(ctx, c, binPath, stagedK3sPath, compressed[idx]); err != nil {
+			return fmt.Errorf("failed to upload k3s binary to %s: %w", nodeLabel(nodes[idx]), err)
+		}
+	}
+	return nil
+}
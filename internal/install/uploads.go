@@ -0,0 +1,56 @@
+package install
+
+import (
+	"context"
+	"sync"
+)
+
+// maxParallelUploads bounds the concurrent small file uploads to one node,
+// so they don't swamp its SFTP subsystem next to the large asset uploads
+const maxParallelUploads = 4
+
+// uploadGroup uploads independent small files to one node concurrently. The
+// uploads must target different paths and not depend on each other.
+type uploadGroup struct {
+	ctx context.Context
+	sem chan struct{}
+	wg  sync.WaitGroup
+	mu  sync.Mutex
+	err error
+}
+
+func newUploadGroup(ctx context.Context) *uploadGroup {
+	return &uploadGroup{ctx: ctx, sem: make(chan struct{}, maxParallelUploads)}
+}
+
+// Go runs upload in the background once a slot is free
+func (g *uploadGroup) Go(upload func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		select {
+		case g.sem <- struct{}{}:
+		case <-g.ctx.Done():
+			g.fail(g.ctx.Err())
+			return
+		}
+		defer func() { <-g.sem }()
+		if err := upload(); err != nil {
+			g.fail(err)
+		}
+	}()
+}
+
+func (g *uploadGroup) fail(err error) {
+	g.mu.Lock()
+	if g.err == nil {
+		g.err = err
+	}
+	g.mu.Unlock()
+}
+
+// Wait waits for all uploads and returns the first error
+func (g *uploadGroup) Wait() error {
+	g.wg.Wait()
+	return g.err
+}
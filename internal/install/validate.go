@@ -0,0 +1,200 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"k3air/internal/config"
+	"k3air/internal/redact"
+)
+
+// nodeCheck is the connectivity result of one node
+type nodeCheck struct {
+	node   config.Node
+	role   string
+	status string
+	err    error
+}
+
+// assetCheck is the availability of one asset source
+type assetCheck struct {
+	asset  string
+	source string
+	status string
+	err    error
+}
+
+// Validate checks that every node accepts an SSH login and runs a command,
+// and that the configured assets can be found, without changing anything.
+// A summary table is printed; the returned error reports whether anything
+// failed.
+func (i *Installer) Validate(ctx context.Context) error {
+	var nodes []nodeCheck
+	for idx, srv := range i.cfg.Servers {
+		role := "server"
+		if idx == 0 {
+			role = "primary"
+		}
+		nodes = append(nodes, nodeCheck{node: srv, role: role})
+	}
+	for _, ag := range i.cfg.Agents {
+		nodes = append(nodes, nodeCheck{node: ag, role: "agent"})
+	}
+	var wg sync.WaitGroup
+	for idx := range nodes {
+		wg.Add(1)
+		go func(nc *nodeCheck) {
+			defer wg.Done()
+			nc.status, nc.err = i.checkNode(ctx, nc.node)
+		}(&nodes[idx])
+	}
+	wg.Wait()
+
+	assets := i.checkAssets(ctx)
+
+	failed := 0
+	w := tabwriter.NewWriter(i.opts.Output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tROLE\tADDRESS\tRESULT")
+	for _, nc := range nodes {
+		result := nc.status
+		if nc.err != nil {
+			failed++
+			result = nc.status + ": " + redact.String(nc.err.Error())
+		}
+		name := nc.node.NodeName
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s:%d\t%s\n", name, nc.role, nc.node.IP, nc.node.Port, result)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "ASSET\tSOURCE\tRESULT")
+	binaryOK := false
+	for _, ac := range assets {
+		result := ac.status
+		if ac.err != nil {
+			result = ac.status + ": " + redact.String(ac.err.Error())
+		} else if ac.asset == "k3s binary" {
+			binaryOK = true
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", ac.asset, ac.source, result)
+	}
+	w.Flush()
+
+	if !binaryOK {
+		failed++
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+// checkNode dials the SSH port of node, logs in and runs a trivial command
+func (i *Installer) checkNode(ctx context.Context, node config.Node) (string, error) {
+	status, dialErr := i.dialNode(ctx, node)
+	// With connect retries the node may still be booting
+	if dialErr != nil && i.opts.ConnectRetries <= 1 {
+		return status, dialErr
+	}
+	c, err := i.connect(ctx, node)
+	if err != nil {
+		if strings.Contains(err.Error(), "unable to authenticate") {
+			return "auth failed", err
+		}
+		if dialErr != nil {
+			return status, dialErr
+		}
+		return "ssh failed", err
+	}
+	defer c.Close()
+	stdout, _, err := c.Run(ctx, "echo k3air && uname -m")
+	if err != nil {
+		return "command failed", err
+	}
+	fields := strings.Fields(stdout)
+	if len(fields) != 2 || fields[0] != "k3air" {
+		return "command failed", fmt.Errorf("unexpected output %q", stdout)
+	}
+	// The architecture picks the release assets checked below
+	i.setNodeArch(node.IP, fields[1])
+	return "ok", nil
+}
+
+// checkAssets reports the availability of every source of the install assets
+func (i *Installer) checkAssets(ctx context.Context) []assetCheck {
+	var checks []assetCheck
+	add := func(asset string, sources ...string) {
+		for _, src := range sources {
+			status, err := i.assetManager.Check(ctx, src)
+			checks = append(checks, assetCheck{asset: asset, source: src, status: status, err: err})
+		}
+	}
+	assets := i.cfg.Assets
+	// Release assets are checked for every architecture the nodes run
+	if i.cfg.ReleaseK3sBinary() {
+		for _, arch := range i.probedArchs() {
+			add("k3s binary", i.releaseSource(releaseBinaryName(arch)))
+		}
+	} else {
+		add("k3s binary", append([]string{assets.K3sBinary}, assets.K3sBinaryURLs...)...)
+	}
+	if i.cfg.ReleaseAirgapTarball() {
+		for _, arch := range i.probedArchs() {
+			add("airgap images", i.releaseSource(releaseAirgapName(arch)))
+		}
+	} else {
+		add("airgap images", append([]string{assets.K3sAirgapTarball}, assets.K3sAirgapTarballURLs...)...)
+	}
+	if m := i.cfg.Cluster.MetalLB; m != nil {
+		add("metallb manifest", m.ManifestSource())
+	}
+	if i.cfg.Cluster.IngressController() == config.IngressControllerNginx {
+		add("ingress-nginx manifest", i.cfg.Cluster.IngressNginx.ManifestSource())
+	}
+	for _, source := range i.cfg.Cluster.ExtraImages {
+		add("extra images", source)
+	}
+	for _, node := range append(append([]config.Node{}, i.cfg.Servers...), i.cfg.Agents...) {
+		for _, h := range append(append([]config.Hook{}, node.PreInstallHooks...), node.PostInstallHooks...) {
+			if h.Script != "" {
+				add("hook script", h.Script)
+			}
+		}
+	}
+	return checks
+}
+
+// Check reports whether source can be resolved without downloading it: local
+// files must exist and URLs must answer a HEAD request with 200 OK
+func (am *AssetManager) Check(ctx context.Context, source string) (string, error) {
+	if !isURL(source) {
+		info, err := os.Stat(source)
+		if err != nil {
+			return "missing", err
+		}
+		return "ok (" + formatBytes(info.Size()) + ")", nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, source, nil)
+	if err != nil {
+		return "invalid", err
+	}
+	am.logProxy(req)
+	resp, err := am.client.Do(req)
+	if err != nil {
+		return "unreachable", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "unavailable", fmt.Errorf("HEAD returned %s", resp.Status)
+	}
+	if resp.ContentLength > 0 {
+		return "ok (" + formatBytes(resp.ContentLength) + ")", nil
+	}
+	return "ok", nil
+}
@@ -0,0 +1,105 @@
+// Package redact masks secrets such as the cluster token and SSH passwords
+// in log output and error messages.
+package redact
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Mask replaces every registered secret
+const Mask = "***"
+
+var (
+	mu sync.RWMutex
+	// secrets are kept longest first, so a secret containing a shorter one
+	// is masked whole
+	secrets []string
+)
+
+// Add registers secrets to be masked from now on. Empty values are
+// ignored; short ones are masked too, even where they appear in unrelated
+// text.
+func Add(values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, v := range values {
+		if v == "" || slices.Contains(secrets, v) {
+			continue
+		}
+		secrets = append(secrets, v)
+	}
+	slices.SortStableFunc(secrets, func(a, b string) int { return len(b) - len(a) })
+}
+
+// String returns s with every registered secret masked
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Mask)
+	}
+	return s
+}
+
+// Handler wraps h so the message and the string, error and Stringer
+// attributes of every record are redacted before h sees them
+func Handler(h slog.Handler) slog.Handler {
+	return handler{h}
+}
+
+type handler struct {
+	h slog.Handler
+}
+
+func (h handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.h.Enabled(ctx, level)
+}
+
+func (h handler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, String(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(attr(a))
+		return true
+	})
+	return h.h.Handle(ctx, out)
+}
+
+func (h handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = attr(a)
+	}
+	return handler{h.h.WithAttrs(redacted)}
+}
+
+func (h handler) WithGroup(name string) slog.Handler {
+	return handler{h.h.WithGroup(name)}
+}
+
+// attr returns a with its value redacted
+func attr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, String(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		redacted := make([]any, len(group))
+		for i, g := range group {
+			redacted[i] = attr(g)
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case error:
+			return slog.String(a.Key, String(x.Error()))
+		case interface{ String() string }:
+			return slog.String(a.Key, String(x.String()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
@@ -0,0 +1,48 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+type stringer string
+
+func (s stringer) String() string { return string(s) }
+
+func TestHandlerMasksSecrets(t *testing.T) {
+	const token = "K10secret-cluster-token"
+	Add(token)
+
+	var buf bytes.Buffer
+	log := slog.New(Handler(slog.NewTextHandler(&buf, nil)))
+	log.Info("joining with token " + token)
+	log.Info("install", "cmd", "k3s server --token "+token)
+	log.Info("install", "err", errors.New("exit 1: --token="+token))
+	log.Info("install", "arg", stringer(token))
+	log.Info("install", slog.Group("k3s", "token", token))
+	log.With("token", token).WithGroup("node").Info("install", "env", "K3S_TOKEN="+token)
+
+	out := buf.String()
+	if strings.Contains(out, token) {
+		t.Fatalf("token in log output:\n%s", out)
+	}
+	if n := strings.Count(out, Mask); n != 7 {
+		t.Errorf("got %d masks, want 7:\n%s", n, out)
+	}
+}
+
+func TestAddMasksShortValues(t *testing.T) {
+	Add("", "pw1", "pw12345")
+	if got := String("login pw1"); got != "login "+Mask {
+		t.Errorf("String(%q) = %q, short secrets must be masked", "login pw1", got)
+	}
+	if got := String("login pw12345"); got != "login "+Mask {
+		t.Errorf("String(%q) = %q, want the longer secret masked whole", "login pw12345", got)
+	}
+	if got := String("plain"); got != "plain" {
+		t.Errorf("String(%q) = %q, the empty value must not be registered", "plain", got)
+	}
+}
@@ -0,0 +1,64 @@
+package sshclient
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The algorithms golang.org/x/crypto/ssh implements on the client side,
+// including the legacy ones it only negotiates when asked to
+var (
+	SupportedCiphers = []string{
+		"aes128-gcm@openssh.com", "aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		"aes128-cbc", "3des-cbc",
+		"arcfour256", "arcfour128", "arcfour",
+	}
+	SupportedKeyExchanges = []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+		"diffie-hellman-group-exchange-sha256", "diffie-hellman-group-exchange-sha1",
+		"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+	}
+	SupportedMACs = []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+		"hmac-sha2-256", "hmac-sha2-512", "hmac-sha1", "hmac-sha1-96",
+	}
+)
+
+// Algorithms restricts the algorithms offered in the SSH handshake; an
+// empty list keeps the crypto/ssh defaults
+type Algorithms struct {
+	Ciphers      []string
+	KeyExchanges []string
+	MACs         []string
+}
+
+// Check reports algorithm names crypto/ssh does not implement
+func (a Algorithms) Check() error {
+	for _, kind := range []struct {
+		name      string
+		names     []string
+		supported []string
+	}{
+		{"cipher", a.Ciphers, SupportedCiphers},
+		{"key exchange", a.KeyExchanges, SupportedKeyExchanges},
+		{"MAC", a.MACs, SupportedMACs},
+	} {
+		for _, n := range kind.names {
+			if !slices.Contains(kind.supported, n) {
+				return fmt.Errorf("unsupported SSH %s %q: must be one of %s", kind.name, n, strings.Join(kind.supported, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// config returns the crypto/ssh config offering the algorithms
+func (a Algorithms) config() ssh.Config {
+	return ssh.Config{Ciphers: a.Ciphers, KeyExchanges: a.KeyExchanges, MACs: a.MACs}
+}
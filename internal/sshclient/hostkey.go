@@ -0,0 +1,134 @@
+package sshclient
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key policies
+const (
+	// HostKeyInsecure accepts any host key without checking
+	HostKeyInsecure = "insecure"
+	// HostKeyStrict only accepts host keys already present in known_hosts
+	HostKeyStrict = "strict"
+	// HostKeyTOFU records unknown host keys on first connect and verifies them afterwards
+	HostKeyTOFU = "tofu"
+)
+
+// knownHostsMu serializes appends to known_hosts files
+var knownHostsMu sync.Mutex
+
+// DefaultKnownHostsPath returns ~/.ssh/known_hosts
+func DefaultKnownHostsPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".ssh", "known_hosts")
+	}
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// hostKeyCallback builds the ssh.HostKeyCallback for the given policy
+func hostKeyCallback(policy, knownHostsPath string) (ssh.HostKeyCallback, error) {
+	if knownHostsPath == "" {
+		knownHostsPath = DefaultKnownHostsPath()
+	}
+	knownHostsPath = expandHome(knownHostsPath)
+	switch policy {
+	case "", HostKeyInsecure:
+		return ssh.InsecureIgnoreHostKey(), nil
+	case HostKeyStrict:
+		cb, err := knownhosts.New(knownHostsPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load known_hosts %s: %w", knownHostsPath, err)
+		}
+		return cb, nil
+	case HostKeyTOFU:
+		return tofuCallback(knownHostsPath)
+	default:
+		return nil, fmt.Errorf("unknown host key policy: %s", policy)
+	}
+}
+
+// tofuCallback trusts unknown hosts on first use by recording their key in
+// knownHostsPath, and rejects hosts whose key differs from the recorded one
+func tofuCallback(knownHostsPath string) (ssh.HostKeyCallback, error) {
+	knownHostsMu.Lock()
+	err := ensureFile(knownHostsPath)
+	knownHostsMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create known_hosts %s: %w", knownHostsPath, err)
+	}
+	cb, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts %s: %w", knownHostsPath, err)
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := cb(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			return fmt.Errorf("HOST KEY MISMATCH for %s: the stored key in %s (line %d) does not match the presented %s key %s; "+
+				"if the host was reinstalled remove the old entry, otherwise this may be a man-in-the-middle attack",
+				hostname, keyErr.Want[0].Filename, keyErr.Want[0].Line, key.Type(), ssh.FingerprintSHA256(key))
+		}
+		if err := appendKnownHost(knownHostsPath, hostname, key); err != nil {
+			return fmt.Errorf("failed to record host key for %s: %w", hostname, err)
+		}
+		slog.Info("trusting new host key", "host", hostname, "type", key.Type(), "fingerprint", ssh.FingerprintSHA256(key))
+		return nil
+	}, nil
+}
+
+// appendKnownHost appends a known_hosts line for hostname
+func appendKnownHost(path, hostname string, key ssh.PublicKey) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	return err
+}
+
+// expandHome expands a leading ~/ to the user's home directory
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+// ensureFile creates path (and its parent directory) if it does not exist
+func ensureFile(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
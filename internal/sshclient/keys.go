@@ -0,0 +1,66 @@
+package sshclient
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// privateKeyBlocks are the PEM block types of the private key formats
+// ssh.ParsePrivateKey understands: OpenSSH (any key type, including
+// ed25519), PKCS#1 RSA, SEC 1 ECDSA, DSA and PKCS#8
+var privateKeyBlocks = []string{
+	"OPENSSH PRIVATE KEY",
+	"RSA PRIVATE KEY",
+	"EC PRIVATE KEY",
+	"DSA PRIVATE KEY",
+	"PRIVATE KEY",
+}
+
+// loadSigner reads and parses the private key at path. Keys that cannot be
+// used are explained: encrypted keys, PuTTY keys, public keys given in place
+// of the private one, unsupported key types and malformed files.
+func loadSigner(path string) (ssh.Signer, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, keyError(path, key, err)
+	}
+	slog.Debug("loaded ssh key", "path", path, "type", signer.PublicKey().Type(),
+		"fingerprint", ssh.FingerprintSHA256(signer.PublicKey()))
+	return signer, nil
+}
+
+// keyError turns the error of parsing the key at path into an actionable one
+func keyError(path string, key []byte, err error) error {
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return fmt.Errorf("ssh key %s is encrypted with a passphrase: load it into ssh-agent (ssh-add %s) or remove the passphrase (ssh-keygen -p -N '' -f %s)", path, path, path)
+	}
+	trimmed := bytes.TrimSpace(key)
+	if bytes.HasPrefix(trimmed, []byte("PuTTY-User-Key-File-")) {
+		return fmt.Errorf("ssh key %s is a PuTTY key: convert it to OpenSSH format with puttygen %s -O private-openssh -o <new path>", path, path)
+	}
+	if _, _, _, _, err := ssh.ParseAuthorizedKey(trimmed); err == nil {
+		return fmt.Errorf("ssh key %s is a public key: key_path must be the private key", path)
+	}
+	block, _ := pem.Decode(trimmed)
+	if block == nil {
+		return fmt.Errorf("ssh key %s is malformed: not a PEM encoded private key", path)
+	}
+	if !slices.Contains(privateKeyBlocks, block.Type) ||
+		strings.Contains(err.Error(), "unsupported") || strings.Contains(err.Error(), "unhandled key type") {
+		return fmt.Errorf("ssh key %s has an unsupported key type (%s): use an ed25519, ecdsa or rsa key: %w", path, block.Type, err)
+	}
+	return fmt.Errorf("ssh key %s is malformed: %w", path, err)
+}
@@ -0,0 +1,78 @@
+package sshclient
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// writeKey writes data to a key file in a new temporary directory and
+// returns its path
+func writeKey(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSigner(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := loadSigner(writeKey(t, pem.EncodeToMemory(block)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := signer.PublicKey(); ssh.FingerprintSHA256(got) != ssh.FingerprintSHA256(want) {
+		t.Errorf("loaded key %s, want %s", ssh.FingerprintSHA256(got), ssh.FingerprintSHA256(want))
+	}
+}
+
+func TestLoadSignerErrors(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ssh.MarshalPrivateKeyWithPassphrase(key, "test", []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"encrypted", pem.EncodeToMemory(encrypted), "is encrypted with a passphrase"},
+		{"putty", []byte("PuTTY-User-Key-File-3: ssh-ed25519\nEncryption: none\n"), "is a PuTTY key"},
+		{"public", ssh.MarshalAuthorizedKey(sshPub), "is a public key"},
+		{"not pem", []byte("not a key"), "is malformed: not a PEM encoded private key"},
+		{"bad pem", pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: []byte("garbage")}), "is malformed:"},
+	}
+	for _, tt := range tests {
+		_, err := loadSigner(writeKey(t, tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
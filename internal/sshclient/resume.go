@@ -0,0 +1,121 @@
+package sshclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+
+	"github.com/schollz/progressbar/v3"
+	"golang.org/x/term"
+)
+
+// UploadResumable is Upload for large files over unreliable links. The file
+// is written to a partial file next to its destination (or in the staging
+// directory with sudo), and an upload that was interrupted, for example by a
+// dropped connection, continues where it stopped the next time the same file
+// is uploaded to the same path. The partial file's name identifies the local
+// file by size and modification time, so a changed local file starts over.
+func (c *Client) UploadResumable(ctx context.Context, localPath, remotePath string, progress bool) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	dir := path.Dir(remotePath)
+	if c.sudo {
+		if dir, err = c.stagingDir(); err != nil {
+			return err
+		}
+	}
+	prefix := path.Join(dir, path.Base(remotePath)+".k3air-part-")
+	part := prefix + partID(remotePath, info)
+
+	var offset int64
+	if fi, err := c.sftp.Stat(part); err == nil && fi.Size() <= info.Size() {
+		offset = fi.Size()
+	}
+	c.removeStaleParts(prefix+"*", part)
+	if offset > 0 {
+		slog.Info("resuming interrupted upload", "path", remotePath, "uploaded", offset, "size", info.Size())
+	}
+
+	var w io.Writer
+	// Progress bars are unreadable escape noise in logs and pipes
+	if progress && term.IsTerminal(int(os.Stdout.Fd())) {
+		bar := progressbar.NewOptions64(info.Size(),
+			progressbar.OptionShowBytes(true),
+			progressbar.OptionSetDescription("upload "+remotePath))
+		bar.Set64(offset)
+		w = bar
+		defer fmt.Println() // Ensure newline after progress bar
+	}
+	if err := c.uploadFrom(ctx, localPath, part, offset, w); err != nil {
+		return err
+	}
+
+	if c.sudo {
+		return c.install(ctx, part, remotePath)
+	}
+	if err := c.sftp.PosixRename(part, remotePath); err != nil {
+		// Servers without the posix-rename extension refuse to replace
+		c.sftp.Remove(remotePath)
+		if err := c.sftp.Rename(part, remotePath); err != nil {
+			return fmt.Errorf("failed to move %s into place: %w", remotePath, err)
+		}
+	}
+	return nil
+}
+
+// partID identifies an upload of the local file info to remotePath
+func partID(remotePath string, info os.FileInfo) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d", remotePath, info.Size(), info.ModTime().UnixNano())))
+	return hex.EncodeToString(sum[:8])
+}
+
+// removeStaleParts deletes the partial files matching pattern except keep,
+// left behind by interrupted uploads of an older version of the file
+func (c *Client) removeStaleParts(pattern, keep string) {
+	matches, err := c.sftp.Glob(pattern)
+	if err != nil {
+		return
+	}
+	for _, m := range matches {
+		if m != keep {
+			slog.Debug("removing stale partial upload", "path", m)
+			c.sftp.Remove(m)
+		}
+	}
+}
+
+// uploadFrom copies localPath to target starting at offset in both files,
+// appending to what an earlier attempt uploaded
+func (c *Client) uploadFrom(ctx context.Context, localPath, target string, offset int64, progress io.Writer) error {
+	lf, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+	rf, err := c.sftp.OpenFile(target, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return err
+	}
+	defer rf.Close()
+	if _, err := lf.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := rf.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	var w io.Writer = rf
+	if progress != nil {
+		w = io.MultiWriter(rf, progress)
+	}
+	if _, err := io.Copy(w, contextReader{ctx, lf}); err != nil {
+		return err
+	}
+	return rf.Close()
+}
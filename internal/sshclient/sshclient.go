@@ -2,22 +2,44 @@ package sshclient
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/pkg/sftp"
 	progressbar "github.com/schollz/progressbar/v3"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+	"k3air/internal/redact"
 )
 
 type Client struct {
 	addr   string
 	client *ssh.Client
 	sftp   *sftp.Client
+
+	// sudo runs commands and writes files through sudo for non-root users
+	sudo         bool
+	sudoPassword string
+	uploadDir    string
+	uploadDirMu  sync.Mutex
+
+	// stopKeepalive ends the keepalive goroutine
+	stopKeepalive chan struct{}
+	// jumps are the ProxyJump connections the client is tunnelled through
+	jumps []*ssh.Client
+	// trace logs every command with its output
+	trace bool
 }
 
 type Auth struct {
@@ -25,13 +47,74 @@ type Auth struct {
 	KeyPath  string
 }
 
-func New(host string, port int, username string, auth Auth) (*Client, error) {
+// Options controls how the connection is established
+type Options struct {
+	// HostKeyPolicy is one of HostKeyInsecure (default), HostKeyStrict or HostKeyTOFU
+	HostKeyPolicy string
+	// KnownHostsPath is the known_hosts file used by the strict and tofu policies
+	KnownHostsPath string
+	// Sudo runs every command through sudo and stages uploads in the user's
+	// home directory before moving them into place
+	Sudo bool
+	// ConnectRetries is the number of dial attempts for hosts that refuse or
+	// time out, e.g. while still booting. Authentication and host key
+	// failures are never retried. Values below 1 mean a single attempt.
+	ConnectRetries int
+	// KeepaliveInterval is how often a keepalive request is sent so idle
+	// connections, such as during long uploads, are not dropped by the
+	// server or middleboxes. Zero uses DefaultKeepaliveInterval, a negative
+	// value disables keepalives.
+	KeepaliveInterval time.Duration
+	// ProxyJump is a comma separated list of [user@]host[:port] jump hosts
+	// the connection is tunnelled through, as in OpenSSH
+	ProxyJump string
+	// SSHConfig resolves the jump hosts' HostName, User, Port and
+	// IdentityFile; may be nil
+	SSHConfig *SSHConfig
+	// Trace logs every command with its full output at debug level
+	Trace bool
+	// Algorithms restricts the ciphers, key exchanges and MACs offered to
+	// the host and the jump hosts, for hardened or legacy servers
+	Algorithms Algorithms
+}
+
+// DefaultKeepaliveInterval is the keepalive interval used when none is set
+const DefaultKeepaliveInterval = 30 * time.Second
+
+// dialTimeout bounds the TCP connect and SSH handshake of a single dial
+const dialTimeout = 20 * time.Second
+
+// connectRetryBackoff is the delay before the first dial retry; it doubles on
+// every further attempt
+const connectRetryBackoff = 2 * time.Second
+
+// AgentHasKeys reports whether the ssh-agent at SSH_AUTH_SOCK is reachable
+// and holds at least one key New could authenticate with
+func AgentHasKeys() bool {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return false
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	keys, err := agent.NewClient(conn).List()
+	return err == nil && len(keys) > 0
+}
+
+// New connects to host and opens an SFTP session. Cancelling ctx aborts the
+// dial and handshake.
+func New(ctx context.Context, host string, port int, username string, auth Auth, opts Options) (*Client, error) {
 	if username == "" {
 		slog.Info("username is empty, use root")
 		username = "root"
 	}
 
 	slog.Debug("establishing SSH connection", "host", host, "port", port, "user", username)
+	// The password doubles as the sudo password, which must never be logged
+	redact.Add(auth.Password)
 
 	var authMethods []ssh.AuthMethod
 	var authMethod string
@@ -40,29 +123,56 @@ func New(host string, port int, username string, auth Auth) (*Client, error) {
 		authMethod = "password"
 	}
 	if auth.KeyPath != "" {
-		key, err := os.ReadFile(auth.KeyPath)
-		if err != nil {
-			return nil, err
-		}
-		signer, err := ssh.ParsePrivateKey(key)
+		signer, err := loadSigner(auth.KeyPath)
 		if err != nil {
 			return nil, err
 		}
 		authMethods = append(authMethods, ssh.PublicKeys(signer))
 		authMethod = "key"
 	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			slog.Debug("ssh-agent not reachable", "socket", sock, "error", err)
+		} else {
+			defer conn.Close()
+			authMethods = append(authMethods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			if authMethod == "" {
+				authMethod = "agent"
+			}
+		}
+	}
+
+	hostKeyCB, err := hostKeyCallback(opts.HostKeyPolicy, opts.KnownHostsPath)
+	if err != nil {
+		return nil, err
+	}
 
 	cfg := &ssh.ClientConfig{
+		Config:          opts.Algorithms.config(),
 		User:            username,
 		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         20 * time.Second,
+		HostKeyCallback: hostKeyCB,
+		Timeout:         dialTimeout,
 	}
 	addr := net.JoinHostPort(host, fmt.Sprintf("%d", port))
 
-	c, err := ssh.Dial("tcp", addr, cfg)
+	jumps, err := dialJumps(ctx, opts, authMethods, hostKeyCB)
 	if err != nil {
-		slog.Debug("SSH connection failed", "error", err)
+		return nil, err
+	}
+	closeJumps := func() {
+		for idx := len(jumps) - 1; idx >= 0; idx-- {
+			jumps[idx].Close()
+		}
+	}
+	var via *ssh.Client
+	if len(jumps) > 0 {
+		via = jumps[len(jumps)-1]
+	}
+	c, err := dialRetry(ctx, via, addr, cfg, opts.ConnectRetries)
+	if err != nil {
+		closeJumps()
 		return nil, err
 	}
 
@@ -71,9 +181,182 @@ func New(host string, port int, username string, auth Auth) (*Client, error) {
 	s, err := sftp.NewClient(c)
 	if err != nil {
 		c.Close()
+		closeJumps()
+		return nil, err
+	}
+	client := &Client{addr: addr, client: c, sftp: s, jumps: jumps, trace: opts.Trace}
+	interval := opts.KeepaliveInterval
+	if interval == 0 {
+		interval = DefaultKeepaliveInterval
+	}
+	if interval > 0 {
+		client.stopKeepalive = make(chan struct{})
+		go client.keepalive(interval, client.stopKeepalive)
+	}
+	if opts.Sudo {
+		slog.Debug("running privileged commands with sudo", "user", username)
+		client.sudo = true
+		client.sudoPassword = auth.Password
+	}
+	return client, nil
+}
+
+// dialJumps connects to the ProxyJump hosts in order, each through the
+// previous one. Jump hosts authenticate with their IdentityFile from the SSH
+// config in addition to the target's auth methods.
+func dialJumps(ctx context.Context, opts Options, authMethods []ssh.AuthMethod, hostKeyCB ssh.HostKeyCallback) ([]*ssh.Client, error) {
+	if opts.ProxyJump == "" {
+		return nil, nil
+	}
+	var jumps []*ssh.Client
+	fail := func(err error) ([]*ssh.Client, error) {
+		for idx := len(jumps) - 1; idx >= 0; idx-- {
+			jumps[idx].Close()
+		}
+		return nil, err
+	}
+	for _, spec := range strings.Split(opts.ProxyJump, ",") {
+		user, hostPort, ok := strings.Cut(strings.TrimSpace(spec), "@")
+		if !ok {
+			user, hostPort = "", user
+		}
+		host, port := hostPort, ""
+		if h, p, err := net.SplitHostPort(hostPort); err == nil {
+			host, port = h, p
+		}
+		hc := opts.SSHConfig.Lookup(host)
+		if hc.HostName != "" {
+			host = hc.HostName
+		}
+		if port == "" {
+			port = "22"
+			if hc.Port != 0 {
+				port = strconv.Itoa(hc.Port)
+			}
+		}
+		if user == "" {
+			user = hc.User
+		}
+		if user == "" {
+			user = "root"
+		}
+		methods := authMethods
+		for _, path := range hc.IdentityFiles {
+			if signer, err := loadSigner(path); err == nil {
+				methods = append([]ssh.AuthMethod{ssh.PublicKeys(signer)}, methods...)
+			} else {
+				slog.Debug("skipping jump host identity file", "path", path, "error", err)
+			}
+		}
+		cfg := &ssh.ClientConfig{
+			Config:          opts.Algorithms.config(),
+			User:            user,
+			Auth:            methods,
+			HostKeyCallback: hostKeyCB,
+			Timeout:         dialTimeout,
+		}
+		var via *ssh.Client
+		if len(jumps) > 0 {
+			via = jumps[len(jumps)-1]
+		}
+		addr := net.JoinHostPort(host, port)
+		slog.Debug("connecting to jump host", "addr", addr, "user", user)
+		jc, err := dialRetry(ctx, via, addr, cfg, opts.ConnectRetries)
+		if err != nil {
+			return fail(fmt.Errorf("jump host %s: %w", addr, err))
+		}
+		jumps = append(jumps, jc)
+	}
+	return jumps, nil
+}
+
+// dialRetry dials addr, through via when it is not nil, up to attempts
+// times, backing off between attempts while the host is unreachable
+func dialRetry(ctx context.Context, via *ssh.Client, addr string, cfg *ssh.ClientConfig, attempts int) (*ssh.Client, error) {
+	backoff := connectRetryBackoff
+	for attempt := 1; ; attempt++ {
+		c, err := dial(ctx, via, addr, cfg)
+		if err == nil {
+			return c, nil
+		}
+		slog.Debug("SSH connection failed", "addr", addr, "attempt", attempt, "error", err)
+		if attempt >= attempts || ctx.Err() != nil || !isTransientDialError(err) {
+			return nil, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// isTransientDialError reports whether a dial may succeed when retried: the
+// host refused, reset or dropped the connection, or did not answer in time.
+// Authentication, host key and protocol errors are permanent.
+func isTransientDialError(err error) bool {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, io.EOF),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EHOSTUNREACH),
+		errors.Is(err, syscall.ENETUNREACH):
+		return true
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return true
+	}
+	return false
+}
+
+// dial is ssh.Dial with support for cancellation through ctx. When via is
+// set, the TCP connection is opened through that SSH connection.
+func dial(ctx context.Context, via *ssh.Client, addr string, cfg *ssh.ClientConfig) (*ssh.Client, error) {
+	var conn net.Conn
+	var err error
+	if via != nil {
+		conn, err = via.DialContext(ctx, "tcp", addr)
+	} else {
+		d := net.Dialer{Timeout: cfg.Timeout}
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The handshake itself is not context aware, so close the connection to
+	// unblock it when ctx is cancelled or the deadline passes
+	conn.SetDeadline(time.Now().Add(cfg.Timeout))
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	sc, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, err
 	}
-	return &Client{addr: addr, client: c, sftp: s}, nil
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(sc, chans, reqs), nil
+}
+
+// keepalive sends an OpenSSH keepalive request every interval until the
+// client is closed, closing stop, or the connection fails
+func (c *Client) keepalive(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if _, _, err := c.client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				slog.Debug("SSH keepalive failed", "addr", c.addr, "error", err)
+				return
+			}
+		}
+	}
 }
 
 func (c *Client) Addr() string {
@@ -81,57 +364,170 @@ func (c *Client) Addr() string {
 }
 
 func (c *Client) Close() {
+	if c.stopKeepalive != nil {
+		close(c.stopKeepalive)
+		c.stopKeepalive = nil
+	}
+	if c.uploadDir != "" {
+		c.sftp.RemoveDirectory(c.uploadDir)
+	}
 	if c.sftp != nil {
 		c.sftp.Close()
 	}
 	if c.client != nil {
 		c.client.Close()
 	}
+	for idx := len(c.jumps) - 1; idx >= 0; idx-- {
+		c.jumps[idx].Close()
+	}
+}
+
+// Run runs cmd and returns its stdout and stderr. When ctx is cancelled the
+// remote process is killed and ctx.Err() is returned.
+func (c *Client) Run(ctx context.Context, cmd string) (string, string, error) {
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	err := c.RunStream(ctx, cmd, &stdout, &stderr)
+	return stdout.String(), stderr.String(), err
+}
+
+// ErrCommandTimeout is wrapped by the error of a command RunWithTimeout
+// gave up on
+var ErrCommandTimeout = errors.New("command timed out")
+
+// RunWithTimeout is Run for commands that may hang, such as systemctl on a
+// wedged node: after d the remote process is killed, its session closed
+// and an error wrapping ErrCommandTimeout is returned.
+func (c *Client) RunWithTimeout(ctx context.Context, cmd string, d time.Duration) (string, string, error) {
+	tctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	stdout, stderr, err := c.Run(tctx, cmd)
+	if err != nil && ctx.Err() == nil && errors.Is(tctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("%w after %s on %s", ErrCommandTimeout, d, c.addr)
+	}
+	return stdout, stderr, err
 }
 
-func (c *Client) Run(cmd string) (string, string, error) {
+// RunStream runs cmd, streaming its output to stdout and stderr as it is
+// produced. When ctx is cancelled the remote process is killed and
+// ctx.Err() is returned.
+func (c *Client) RunStream(ctx context.Context, cmd string, stdout, stderr io.Writer) (err error) {
 	s, err := c.client.NewSession()
 	if err != nil {
-		return "", "", err
+		return err
 	}
 	defer s.Close()
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	s.Stdout = &stdout
-	s.Stderr = &stderr
-	err = s.Run(cmd)
-	return stdout.String(), stderr.String(), err
+	// Keep a copy of stderr to recognize sudo failures
+	var errBuf bytes.Buffer
+	s.Stdout = stdout
+	s.Stderr = io.MultiWriter(stderr, &errBuf)
+	var outBuf bytes.Buffer
+	if c.trace {
+		s.Stdout = io.MultiWriter(stdout, &outBuf)
+		start := time.Now()
+		traced := cmd
+		defer func() { c.traceCommand(traced, outBuf.String(), errBuf.String(), time.Since(start), err) }()
+	}
+	if c.sudo {
+		cmd, s.Stdin = c.sudoCommand(cmd)
+	}
+	if err = s.Start(cmd); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Wait() }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		s.Signal(ssh.SIGKILL)
+		s.Close()
+		return ctx.Err()
+	}
+	if err != nil && c.sudo {
+		err = c.sudoError(errBuf.String(), err)
+	}
+	return err
 }
 
-func (c *Client) Upload(localPath, remotePath string, progress bool) error {
+// Upload copies localPath to remotePath, showing a progress bar when progress
+// is set and stdout is a terminal
+func (c *Client) Upload(ctx context.Context, localPath, remotePath string, progress bool) error {
+	// Progress bars are unreadable escape noise in logs and pipes
+	if !progress || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return c.UploadWithProgress(ctx, localPath, remotePath, nil)
+	}
+	stat, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	bar := progressbar.NewOptions(int(stat.Size()),
+		progressbar.OptionShowBytes(true),
+		progressbar.OptionSetDescription("upload "+remotePath))
+	err = c.UploadWithProgress(ctx, localPath, remotePath, bar)
+	fmt.Println() // Ensure newline after progress bar
+	return err
+}
+
+// UploadWithProgress copies localPath to remotePath and writes every uploaded
+// chunk to progress as well, so several uploads can share one progress bar.
+// progress may be nil.
+func (c *Client) UploadWithProgress(ctx context.Context, localPath, remotePath string, progress io.Writer) error {
+	target := remotePath
+	if c.sudo {
+		staged, err := c.stagingPath(remotePath)
+		if err != nil {
+			return err
+		}
+		target = staged
+	}
+	if err := c.upload(ctx, localPath, target, progress); err != nil {
+		return err
+	}
+	if c.sudo {
+		return c.install(ctx, target, remotePath)
+	}
+	return nil
+}
+
+func (c *Client) upload(ctx context.Context, localPath, target string, progress io.Writer) error {
 	lf, err := os.Open(localPath)
 	if err != nil {
 		return err
 	}
 	defer lf.Close()
-	rf, err := c.sftp.Create(remotePath)
+	rf, err := c.sftp.Create(target)
 	if err != nil {
 		return err
 	}
 	defer rf.Close()
-	if progress {
-		stat, e := lf.Stat()
-		if e != nil {
-			return e
-		}
-		bar := progressbar.NewOptions(int(stat.Size()),
-			progressbar.OptionShowBytes(true),
-			progressbar.OptionSetDescription("upload "+remotePath))
-		_, err = io.Copy(io.MultiWriter(rf, bar), lf)
-		fmt.Println() // Ensure newline after progress bar
-	} else {
-		_, err = io.Copy(rf, lf)
+	var w io.Writer = rf
+	if progress != nil {
+		w = io.MultiWriter(rf, progress)
 	}
+	_, err = io.Copy(w, contextReader{ctx, lf})
 	return err
 }
 
-func (c *Client) UploadBytes(data []byte, remotePath string) error {
-	rf, err := c.sftp.Create(remotePath)
+func (c *Client) UploadBytes(ctx context.Context, data []byte, remotePath string) error {
+	target := remotePath
+	if c.sudo {
+		staged, err := c.stagingPath(remotePath)
+		if err != nil {
+			return err
+		}
+		target = staged
+	}
+	if err := c.uploadBytes(data, target); err != nil {
+		return err
+	}
+	if c.sudo {
+		return c.install(ctx, target, remotePath)
+	}
+	return nil
+}
+
+func (c *Client) uploadBytes(data []byte, target string) error {
+	rf, err := c.sftp.Create(target)
 	if err != nil {
 		return err
 	}
@@ -140,11 +536,25 @@ func (c *Client) UploadBytes(data []byte, remotePath string) error {
 	return err
 }
 
-func (c *Client) MkdirAll(remotePath string) error {
+func (c *Client) MkdirAll(ctx context.Context, remotePath string) error {
+	if c.sudo {
+		_, stderr, err := c.Run(ctx, "mkdir -p "+ShellQuote(remotePath))
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr))
+		}
+		return nil
+	}
 	return c.sftp.MkdirAll(remotePath)
 }
 
-func (c *Client) Download(remotePath, localPath string) error {
+func (c *Client) Download(ctx context.Context, remotePath, localPath string) error {
+	if c.sudo {
+		data, err := c.DownloadBytes(ctx, remotePath)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(localPath, data, 0600)
+	}
 	rf, err := c.sftp.Open(remotePath)
 	if err != nil {
 		return err
@@ -155,21 +565,35 @@ func (c *Client) Download(remotePath, localPath string) error {
 		return err
 	}
 	defer lf.Close()
-	_, err = io.Copy(lf, rf)
+	_, err = io.Copy(lf, contextReader{ctx, rf})
 	return err
 }
 
-func (c *Client) DownloadBytes(remotePath string) ([]byte, error) {
+func (c *Client) DownloadBytes(ctx context.Context, remotePath string) ([]byte, error) {
+	if c.sudo {
+		stdout, stderr, err := c.Run(ctx, "cat "+ShellQuote(remotePath))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr))
+		}
+		return []byte(stdout), nil
+	}
 	rf, err := c.sftp.Open(remotePath)
 	if err != nil {
 		return nil, err
 	}
 	defer rf.Close()
-	return io.ReadAll(rf)
+	return io.ReadAll(contextReader{ctx, rf})
 }
 
 // GetFileSize returns the size of a remote file
-func (c *Client) GetFileSize(remotePath string) (int64, error) {
+func (c *Client) GetFileSize(ctx context.Context, remotePath string) (int64, error) {
+	if c.sudo {
+		stdout, stderr, err := c.Run(ctx, "stat -c %s "+ShellQuote(remotePath))
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr))
+		}
+		return strconv.ParseInt(strings.TrimSpace(stdout), 10, 64)
+	}
 	rf, err := c.sftp.Open(remotePath)
 	if err != nil {
 		return 0, err
@@ -181,3 +605,16 @@ func (c *Client) GetFileSize(remotePath string) (int64, error) {
 	}
 	return fi.Size(), nil
 }
+
+// contextReader fails reads once ctx is done, aborting long copies
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
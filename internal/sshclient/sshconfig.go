@@ -0,0 +1,141 @@
+package sshclient
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SSHConfig is the subset of an OpenSSH client config file k3air uses to
+// connect: the Host blocks with their HostName, User, Port, IdentityFile and
+// ProxyJump settings. Other keywords, including Match and Include, are
+// ignored.
+type SSHConfig struct {
+	blocks []hostBlock
+}
+
+type hostBlock struct {
+	patterns []string
+	options  [][2]string
+}
+
+// HostConfig is the connection settings of one host from an SSHConfig. Empty
+// fields are not set by the config.
+type HostConfig struct {
+	HostName      string
+	User          string
+	Port          int
+	IdentityFiles []string
+	ProxyJump     string
+}
+
+// DefaultSSHConfigPath returns the path of the user's OpenSSH client config
+func DefaultSSHConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", "config")
+}
+
+// LoadSSHConfig parses the OpenSSH client config at path. A missing file
+// yields an empty config.
+func LoadSSHConfig(path string) (*SSHConfig, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &SSHConfig{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Settings before the first Host line apply to every host
+	cfg := &SSHConfig{blocks: []hostBlock{{patterns: []string{"*"}}}}
+	sc := bufio.NewScanner(f)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, " ")
+		if k, v, eq := strings.Cut(line, "="); eq && (!ok || len(k) < len(key)) {
+			key, value, ok = k, v, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: missing value for %s", path, lineNo, line)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		if key == "host" {
+			cfg.blocks = append(cfg.blocks, hostBlock{patterns: strings.Fields(value)})
+			continue
+		}
+		last := &cfg.blocks[len(cfg.blocks)-1]
+		last.options = append(last.options, [2]string{key, value})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Lookup returns the settings for host. As with OpenSSH, the first value of
+// each setting wins, in file order; IdentityFile values accumulate.
+func (c *SSHConfig) Lookup(host string) HostConfig {
+	var hc HostConfig
+	if c == nil {
+		return hc
+	}
+	for _, b := range c.blocks {
+		if !b.matches(host) {
+			continue
+		}
+		for _, opt := range b.options {
+			key, value := opt[0], opt[1]
+			switch key {
+			case "hostname":
+				if hc.HostName == "" {
+					hc.HostName = strings.ReplaceAll(value, "%h", host)
+				}
+			case "user":
+				if hc.User == "" {
+					hc.User = value
+				}
+			case "port":
+				if hc.Port == 0 {
+					hc.Port, _ = strconv.Atoi(value)
+				}
+			case "identityfile":
+				hc.IdentityFiles = append(hc.IdentityFiles, expandHome(value))
+			case "proxyjump":
+				if hc.ProxyJump == "" && value != "none" {
+					hc.ProxyJump = value
+				}
+			}
+		}
+	}
+	return hc
+}
+
+// matches reports whether host matches the block's patterns: at least one
+// positive pattern and none of the negated ones
+func (b hostBlock) matches(host string) bool {
+	matched := false
+	for _, p := range b.patterns {
+		negate := strings.HasPrefix(p, "!")
+		p = strings.TrimPrefix(p, "!")
+		// path.Match implements the same * and ? wildcards
+		if ok, _ := path.Match(p, host); ok {
+			if negate {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
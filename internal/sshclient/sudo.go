@@ -0,0 +1,84 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrSudoAuth is wrapped by errors caused by sudo refusing to run a command
+var ErrSudoAuth = errors.New("sudo authentication failed")
+
+// uploadSeq makes staged upload file names unique within the process
+var uploadSeq atomic.Int64
+
+// ShellQuote quotes s for safe use as a single POSIX shell word
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sudoCommand wraps cmd so it runs as root through sudo. When a password is
+// available it is fed to sudo on stdin, otherwise sudo runs non-interactively
+// and fails instead of prompting.
+func (c *Client) sudoCommand(cmd string) (string, io.Reader) {
+	if c.sudoPassword != "" {
+		return "sudo -S -p '' sh -c " + ShellQuote(cmd), strings.NewReader(c.sudoPassword + "\n")
+	}
+	return "sudo -n sh -c " + ShellQuote(cmd), nil
+}
+
+// sudoError turns sudo's authentication failures into an actionable error
+func (c *Client) sudoError(stderr string, err error) error {
+	switch {
+	case strings.Contains(stderr, "a password is required"):
+		return fmt.Errorf("%w: sudo on %s requires a password but none is configured: set the node password or allow NOPASSWD sudo", ErrSudoAuth, c.addr)
+	case strings.Contains(stderr, "incorrect password"), strings.Contains(stderr, "Sorry, try again"):
+		return fmt.Errorf("%w: sudo on %s rejected the configured password", ErrSudoAuth, c.addr)
+	case strings.Contains(stderr, "is not in the sudoers file"):
+		return fmt.Errorf("%w: user on %s is not allowed to use sudo", ErrSudoAuth, c.addr)
+	}
+	return err
+}
+
+// stagingPath returns a user-writable path that remotePath is uploaded to
+// before being moved into place with sudo
+func (c *Client) stagingPath(remotePath string) (string, error) {
+	dir, err := c.stagingDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, fmt.Sprintf("%d-%s", uploadSeq.Add(1), path.Base(remotePath))), nil
+}
+
+// stagingDir returns the directory in the user's home that uploads are
+// staged in, creating it on first use
+func (c *Client) stagingDir() (string, error) {
+	c.uploadDirMu.Lock()
+	defer c.uploadDirMu.Unlock()
+	if c.uploadDir == "" {
+		home, err := c.sftp.Getwd()
+		if err != nil {
+			return "", fmt.Errorf("failed to determine home directory: %w", err)
+		}
+		dir := path.Join(home, ".k3air-upload")
+		if err := c.sftp.MkdirAll(dir); err != nil {
+			return "", fmt.Errorf("failed to create upload staging directory: %w", err)
+		}
+		c.uploadDir = dir
+	}
+	return c.uploadDir, nil
+}
+
+// install moves a staged file to its final root-owned location
+func (c *Client) install(ctx context.Context, staged, remotePath string) error {
+	cmd := fmt.Sprintf("mv -f %s %s && chown root:root %s", ShellQuote(staged), ShellQuote(remotePath), ShellQuote(remotePath))
+	if _, stderr, err := c.Run(ctx, cmd); err != nil {
+		c.sftp.Remove(staged)
+		return fmt.Errorf("failed to move %s into place: %w: %s", remotePath, err, strings.TrimSpace(stderr))
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package sshclient
+
+import (
+	"log/slog"
+	"time"
+)
+
+// traceCommand logs cmd with its full output at debug level, so a failed
+// install can be reconstructed from the log. Secrets are masked by the
+// redacting log handler.
+func (c *Client) traceCommand(cmd, stdout, stderr string, elapsed time.Duration, err error) {
+	attrs := []any{"node", c.addr, "cmd", cmd, "duration", elapsed.Round(time.Millisecond)}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	attrs = append(attrs, "stdout", stdout, "stderr", stderr)
+	slog.Debug("remote command", attrs...)
+}
@@ -0,0 +1,147 @@
+// Package state persists what k3air installed, so later commands know the
+// topology of the running cluster even after the config has been edited.
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaVersion is the version of the state file format written by this
+// build. Files without a version predate versioning and are read as version 1.
+const SchemaVersion = 1
+
+// FileName is the name of the state file, stored next to the config file
+const FileName = "k3air-state.yaml"
+
+// Node roles
+const (
+	RolePrimary = "primary"
+	RoleServer  = "server"
+	RoleAgent   = "agent"
+)
+
+// State is the installed cluster as recorded by k3air
+type State struct {
+	SchemaVersion int `yaml:"schema-version"`
+	// Token is the cluster token the nodes were installed with
+	Token string `yaml:"token"`
+	// K3sVersion is the version of the primary server
+	K3sVersion string    `yaml:"k3s-version"`
+	CreatedAt  time.Time `yaml:"created-at"`
+	UpdatedAt  time.Time `yaml:"updated-at"`
+	Nodes      []Node    `yaml:"nodes"`
+
+	mu sync.Mutex
+}
+
+// Node is an installed node
+type Node struct {
+	Name        string    `yaml:"name"`
+	IP          string    `yaml:"ip"`
+	Role        string    `yaml:"role"`
+	K3sVersion  string    `yaml:"k3s-version"`
+	InstalledAt time.Time `yaml:"installed-at"`
+	UpdatedAt   time.Time `yaml:"updated-at"`
+}
+
+// Path returns the state file belonging to the config file at configPath
+func Path(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), FileName)
+}
+
+// Load reads the state file at path. A missing file yields an empty state,
+// as before the first apply.
+func Load(path string) (*State, error) {
+	s := &State{SchemaVersion: SchemaVersion}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	s.SchemaVersion = 0
+	if err := yaml.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	switch {
+	case s.SchemaVersion == 0:
+		s.SchemaVersion = SchemaVersion
+	case s.SchemaVersion > SchemaVersion:
+		return nil, fmt.Errorf("state file %s has schema version %d, this k3air only supports up to %d: upgrade k3air", path, s.SchemaVersion, SchemaVersion)
+	}
+	return s, nil
+}
+
+// Empty reports whether nothing has been installed yet
+func (s *State) Empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.Nodes) == 0
+}
+
+// SetNode records n, replacing the entry with the same IP. The install time
+// of an existing entry is kept.
+func (s *State) SetNode(n Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	n.UpdatedAt = now
+	for idx, old := range s.Nodes {
+		if old.IP == n.IP {
+			if n.InstalledAt.IsZero() {
+				n.InstalledAt = old.InstalledAt
+			}
+			s.Nodes[idx] = n
+			return
+		}
+	}
+	if n.InstalledAt.IsZero() {
+		n.InstalledAt = now
+	}
+	s.Nodes = append(s.Nodes, n)
+}
+
+// FindNode returns the recorded node with the given IP
+func (s *State) FindNode(ip string) (Node, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range s.Nodes {
+		if n.IP == ip {
+			return n, true
+		}
+	}
+	return Node{}, false
+}
+
+// Save writes the state to path. The file holds the cluster token, so it is
+// only readable by the owner, and it is replaced atomically.
+func (s *State) Save(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = now
+	}
+	s.UpdatedAt = now
+	s.SchemaVersion = SchemaVersion
+	b, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
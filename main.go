@@ -2,17 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"regexp"
 	"strings"
 	"time"
 
 	"k3air/internal/config"
-	"k3air/internal/install"
+	"k3air/internal/redact"
 	"k3air/internal/version"
 )
 
@@ -24,6 +26,16 @@ type textHandler struct {
 	writer  io.Writer
 	level   slog.Level
 	enabled func(context.Context, slog.Level) bool
+	// attrs are the attributes added through WithAttrs
+	attrs []prefixedAttr
+	// prefix is the dotted group path added through WithGroup
+	prefix string
+}
+
+// prefixedAttr is a preset attribute with the group path it was added under
+type prefixedAttr struct {
+	prefix string
+	attr   slog.Attr
 }
 
 func newTextHandler(w io.Writer, level slog.Level) *textHandler {
@@ -53,12 +65,12 @@ func (h *textHandler) Handle(ctx context.Context, r slog.Record) error {
 	// Write message
 	sb.WriteString(r.Message)
 
-	// Write attributes
+	// Write attributes, preset ones first
+	for _, pa := range h.attrs {
+		writeAttr(&sb, pa.prefix, pa.attr)
+	}
 	r.Attrs(func(a slog.Attr) bool {
-		sb.WriteString(" ")
-		sb.WriteString(a.Key)
-		sb.WriteString("=")
-		sb.WriteString(a.Value.String())
+		writeAttr(&sb, h.prefix, a)
 		return true
 	})
 
@@ -68,18 +80,68 @@ func (h *textHandler) Handle(ctx context.Context, r slog.Record) error {
 	return err
 }
 
+// writeAttr writes a as " key=value", resolving LogValuers and flattening
+// group values into dotted keys. Empty attributes are skipped, as slog's
+// built-in handlers do.
+func writeAttr(sb *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			writeAttr(sb, prefix, ga)
+		}
+		return
+	}
+	sb.WriteString(" ")
+	sb.WriteString(prefix + a.Key)
+	sb.WriteString("=")
+	sb.WriteString(a.Value.String())
+}
+
 func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	return h
+	if len(attrs) == 0 {
+		return h
+	}
+	h2 := *h
+	h2.attrs = make([]prefixedAttr, 0, len(h.attrs)+len(attrs))
+	h2.attrs = append(h2.attrs, h.attrs...)
+	// Attributes keep the group path that was current when they were added
+	for _, a := range attrs {
+		h2.attrs = append(h2.attrs, prefixedAttr{h.prefix, a})
+	}
+	return &h2
 }
 
 func (h *textHandler) WithGroup(name string) slog.Handler {
-	return h
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run executes the command line and returns the process exit code. Commands
+// return instead of calling os.Exit so deferred cleanup always runs, including
+// after Ctrl-C or a timeout.
+func run() int {
 	// Global flags
 	showVersion := flag.Bool("version", false, "show version information")
 	showVersionShort := flag.Bool("v", false, "show version information (short)")
+	timeout := flag.Duration("timeout", 0, "abort the command after this long, e.g. 30m (0 means no limit)")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
+	logFile := flag.String("log-file", "", "also append logs and the install summary to this file")
+	strictEnv := flag.Bool("strict-env", false, "fail when the config references an unset environment variable")
+	assetsDir := flag.String("assets-dir", "", "directory that relative paths in the config's assets section are relative to (default: the config file's directory)")
 
 	// Parse global flags
 	flag.Parse()
@@ -87,88 +149,161 @@ func main() {
 	// Handle version flag
 	if *showVersion || *showVersionShort {
 		printVersion()
-		os.Exit(0)
+		return 0
 	}
 
-	// Check if a command is provided
-	if len(os.Args) < 2 {
-		printUsage()
-		os.Exit(1)
+	if *logFormat != "text" && *logFormat != "json" {
+		fmt.Printf("invalid --log-format %q: must be text or json\n", *logFormat)
+		return 1
 	}
 
-	apply := flag.NewFlagSet("apply", flag.ExitOnError)
-	cfgPath := apply.String("f", "init.yaml", "path to config.yaml")
-	verbose := apply.Bool("verbose", false, "enable verbose logging")
+	// out is where logs and the install summary go: stdout, plus the log
+	// file without color codes when --log-file is set. errOut is the same
+	// for stderr.
+	var out, errOut io.Writer = os.Stdout, os.Stderr
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Println("failed to open log file:", err)
+			return 1
+		}
+		defer f.Close()
+		out = io.MultiWriter(os.Stdout, ansiStripper{f})
+		errOut = io.MultiWriter(os.Stderr, ansiStripper{f})
+	}
 
-	init := flag.NewFlagSet("init", flag.ExitOnError)
-	switch os.Args[1] {
-	case "apply":
-		apply.Parse(os.Args[2:])
+	loadOpts := config.LoadOptions{StrictEnv: *strictEnv, PromptPasswords: true, AssetsDir: *assetsDir}
 
-		// Configure log level based on verbose flag
-		logLevel := slog.LevelInfo
-		if *verbose {
-			logLevel = slog.LevelDebug
-		}
+	// Check if a command is provided
+	args := flag.Args()
+	if len(args) < 1 {
+		printUsage(newCommands())
+		return 1
+	}
 
-		// Use custom handler with formatted time
-		handler := newTextHandler(os.Stdout, logLevel)
-		logger := slog.New(handler)
-		slog.SetDefault(logger)
+	// Ctrl-C and the global timeout cancel in-flight remote operations
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
 
-		cfg, err := config.Load(*cfgPath)
-		if err != nil {
-			fmt.Println("failed to load config:", err)
-			os.Exit(1)
-		}
-		slog.Info("cluster config", "pod cidr", cfg.Cluster.ClusterCidr, "service cidr", cfg.Cluster.ServiceCidr)
-		assetsDir := filepath.Join("assets")
-		inst, err := install.NewInstaller(cfg, assetsDir, *verbose)
-		if err != nil {
-			slog.Error("failed to create installer", "error", err)
-			os.Exit(1)
-		}
-		defer func() {
-			if err := inst.Cleanup(); err != nil {
-				slog.Warn("cleanup failed", "error", err)
-			}
-		}()
-		if err := inst.Apply(); err != nil {
-			slog.Error("apply failed", "error", err)
-			os.Exit(1)
-		}
-		fmt.Println("apply completed")
-	case "init":
-		init.Parse(os.Args[2:])
-		out := filepath.Join(".", "init.yaml")
-		if _, err := os.Stat(out); err == nil {
-			fmt.Println("init.yaml already exists")
-			os.Exit(1)
-		}
-		// Read embedded template
-		content, err := config.GetTemplate()
-		if err != nil {
-			fmt.Println("failed to read template:", err)
-			os.Exit(1)
+	e := &env{out: out, logFormat: *logFormat, loadOpts: loadOpts, errOut: errOut}
+	cmds := newCommands()
+	registry := make(map[string]*command, len(cmds))
+	for _, c := range cmds {
+		registry[c.name] = c
+	}
+	cmd, ok := registry[args[0]]
+	if !ok {
+		printUsage(cmds)
+		return 1
+	}
+	if err := cmd.parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
 		}
-		// Write to init.yaml
-		if err := os.WriteFile(out, content, 0644); err != nil {
-			fmt.Println("failed to write init.yaml:", err)
-			os.Exit(1)
+		return 2
+	}
+	return cmd.run(ctx, e)
+}
+
+// setupLogger installs the default slog logger writing to w, using the custom
+// text handler or slog's JSON handler depending on format
+func setupLogger(w io.Writer, verbose bool, format string) {
+	// Configure log level based on verbose flag
+	logLevel := slog.LevelInfo
+	if verbose {
+		logLevel = slog.LevelDebug
+	}
+
+	// Use custom handler with formatted time, or plain JSON for log pipelines
+	var handler slog.Handler = newTextHandler(w, logLevel)
+	if format == "json" {
+		handler = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: logLevel})
+	}
+	// Secrets such as the token never reach the log, whatever logs them
+	logger := slog.New(redact.Handler(handler))
+	slog.SetDefault(logger)
+}
+
+// ansiEscape matches ANSI color and cursor control sequences
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// ansiStripper writes to w with ANSI escape sequences removed, keeping log
+// files readable
+type ansiStripper struct {
+	w io.Writer
+}
+
+func (a ansiStripper) Write(p []byte) (int, error) {
+	if _, err := a.w.Write(ansiEscape.ReplaceAll(p, nil)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// nodeList collects comma separated node names or IPs, from one or more
+// flags
+type nodeList []string
+
+func (n *nodeList) String() string {
+	return strings.Join(*n, ",")
+}
+
+func (n *nodeList) Set(list string) error {
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*n = append(*n, s)
 		}
-		fmt.Println("created init.yaml ✅，please edit it and run k3air apply -f init.yaml")
-		os.Exit(0)
-	default:
-		printUsage()
-		os.Exit(1)
 	}
+	return nil
+}
+
+// nodeSpecs collects repeated --node flags
+type nodeSpecs []config.Node
+
+func (n *nodeSpecs) String() string {
+	return fmt.Sprintf("%d nodes", len(*n))
+}
+
+func (n *nodeSpecs) Set(spec string) error {
+	node, err := config.ParseNodeSpec(spec)
+	if err != nil {
+		return err
+	}
+	*n = append(*n, node)
+	return nil
 }
 
-func printUsage() {
+// usageWidth is the column command summaries are aligned to
+const usageWidth = 32
+
+func printUsage(cmds []*command) {
 	fmt.Println("usage:")
-	fmt.Println("  k3air apply -f <config path>   Deploy a k3s cluster")
-	fmt.Println("  k3air init                     Create a default config.yaml")
+	for _, c := range cmds {
+		synopsis := "  k3air " + c.name
+		if c.usage != "" {
+			synopsis += " " + c.usage
+		}
+		// Long synopses get the summary on their own line
+		if len(synopsis) <= usageWidth {
+			fmt.Printf("%-*s %s\n", usageWidth, synopsis, c.summary)
+		} else {
+			fmt.Println(synopsis)
+			fmt.Printf("%-*s %s\n", usageWidth, "", c.summary)
+		}
+	}
 	fmt.Println("  k3air --version, -v            Show version information")
+	fmt.Println()
+	fmt.Println("global flags (before the command):")
+	fmt.Println("  --timeout <duration>           Abort the command after this long, e.g. --timeout 30m")
+	fmt.Println("  --log-format text|json         Log output format (default text)")
+	fmt.Println("  --log-file <path>              Also append logs to a file")
+	fmt.Println("  --strict-env                   Fail on unset ${VAR} references in the config")
+	fmt.Println("  --assets-dir <path>            Resolve relative asset paths here instead of next to the config")
 }
 
 func printVersion() {
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestTextHandlerWithAttrsAndGroups(t *testing.T) {
+	var buf bytes.Buffer
+	h := newTextHandler(&buf, slog.LevelInfo).
+		WithAttrs([]slog.Attr{slog.String("node", "k3s-server-1")}).
+		WithGroup("k3s").
+		WithAttrs([]slog.Attr{slog.String("version", "v1.31.4+k3s1")})
+
+	r := slog.NewRecord(time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC), slog.LevelInfo, "installing", 0)
+	r.AddAttrs(slog.Int("step", 2), slog.Group("disk", slog.String("free", "4G")), slog.Attr{})
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	want := "2024-05-06 07:08:09 INFO installing node=k3s-server-1 k3s.version=v1.31.4+k3s1 k3s.step=2 k3s.disk.free=4G\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestTextHandlerLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(newTextHandler(&buf, slog.LevelInfo)).With("node", "k3s-agent-0").WithGroup("upload")
+	log.Debug("hidden")
+	log.Info("done", "bytes", 1024)
+
+	got := buf.String()
+	// Skip the timestamp, which comes from the clock
+	const suffix = " INFO done node=k3s-agent-0 upload.bytes=1024\n"
+	if len(got) != len(timeFormat)+len(suffix) || got[len(timeFormat):] != suffix {
+		t.Errorf("got %q, want a timestamp followed by %q", got, suffix)
+	}
+}